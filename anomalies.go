@@ -0,0 +1,76 @@
+package goharmony
+
+import "fmt"
+
+// AnomalyKind identifies a structural oddity in a response
+type AnomalyKind string
+
+const (
+	// AnomalyAnalysisAfterFinal is an analysis message following a final answer
+	AnomalyAnalysisAfterFinal AnomalyKind = "analysis_after_final"
+	// AnomalyUnreasonedCall is a tool call not directly preceded by analysis or commentary
+	AnomalyUnreasonedCall AnomalyKind = "unreasoned_call"
+	// AnomalyDuplicateFinal is a second final message within the same turn
+	AnomalyDuplicateFinal AnomalyKind = "duplicate_final"
+)
+
+// Anomaly describes a structural oddity found by DetectAnomalies
+type Anomaly struct {
+	// Kind of anomaly
+	Kind AnomalyKind `json:"kind"`
+	// Index of the offending message in the parsed response
+	Index int `json:"index"`
+	// Detail describes the anomaly
+	Detail string `json:"detail"`
+}
+
+// DetectAnomalies reports structural oddities in a response for monitoring model
+// behavior. Checks apply within a turn, which restarts whenever a role other than
+// the assistant or a tool speaks.
+func (p *Parser) DetectAnomalies(content string) []Anomaly {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	var anomalies []Anomaly
+	finalIndex := -1
+	for i, msg := range messages {
+		if msg.Role != p.config.DefaultRole && msg.Role != "assistant" && !isToolRole(msg.Role) {
+			finalIndex = -1
+			continue
+		}
+
+		switch {
+		case msg.IsCall:
+			if i == 0 || !isReasoning(messages[i-1]) {
+				anomalies = append(anomalies, Anomaly{
+					Kind:   AnomalyUnreasonedCall,
+					Index:  i,
+					Detail: fmt.Sprintf("call to %s has no preceding reasoning", msg.To),
+				})
+			}
+		case msg.Channel == ChannelAnalysis && finalIndex != -1:
+			anomalies = append(anomalies, Anomaly{
+				Kind:   AnomalyAnalysisAfterFinal,
+				Index:  i,
+				Detail: fmt.Sprintf("analysis follows the final message at index %d", finalIndex),
+			})
+		case msg.Channel == ChannelFinal && finalIndex != -1:
+			anomalies = append(anomalies, Anomaly{
+				Kind:   AnomalyDuplicateFinal,
+				Index:  i,
+				Detail: fmt.Sprintf("final message repeats the final message at index %d", finalIndex),
+			})
+		case msg.Channel == ChannelFinal:
+			finalIndex = i
+		}
+	}
+	return anomalies
+}
+
+// isReasoning checks if a message is assistant analysis or commentary text
+func isReasoning(msg Message) bool {
+	return !msg.IsCall && !isToolRole(msg.Role) &&
+		(msg.Channel == ChannelAnalysis || msg.Channel == ChannelCommentary)
+}
@@ -0,0 +1,54 @@
+package goharmony
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectAnomalies(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []Anomaly
+	}{
+		{
+			name: "Well-formed response",
+			input: `<|start|>user<|message|>Weather?<|end|>
+<|channel|>analysis<|message|>Need data<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>final<|message|>Sunny<|end|>
+<|start|>user<|message|>Thanks<|end|>
+<|channel|>final<|message|>You're welcome<|end|>`,
+		},
+		{
+			name: "Analysis after final and unreasoned call",
+			input: `<|channel|>final<|message|>Sunny<|end|>
+<|channel|>analysis<|message|>Wait, let me double check<|end|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|channel|>commentary to=functions.search<|message|>{"q": "news"}<|call|>`,
+			expected: []Anomaly{
+				{Kind: AnomalyAnalysisAfterFinal, Index: 1, Detail: "analysis follows the final message at index 0"},
+				{Kind: AnomalyUnreasonedCall, Index: 3, Detail: "call to functions.search has no preceding reasoning"},
+			},
+		},
+		{
+			name: "Duplicate final",
+			input: `<|channel|>final<|message|>Sunny<|end|>
+<|channel|>final<|message|>Sunny again<|end|>`,
+			expected: []Anomaly{
+				{Kind: AnomalyDuplicateFinal, Index: 1, Detail: "final message repeats the final message at index 0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anomalies := parser.DetectAnomalies(tt.input)
+			if !reflect.DeepEqual(anomalies, tt.expected) {
+				t.Errorf("DetectAnomalies() = %v, want %v", anomalies, tt.expected)
+			}
+		})
+	}
+}
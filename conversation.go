@@ -0,0 +1,63 @@
+package goharmony
+
+import "strings"
+
+// Conversation is a transcript grouped into turns
+type Conversation struct {
+	// Turns in transcript order
+	Turns []Turn `json:"turns"`
+}
+
+// Turn groups consecutive messages from one participant. An assistant turn holds
+// its analysis, commentary, tool calls and final messages together with the tool
+// results sent back to it, and ends at <|return|> or when another role speaks.
+type Turn struct {
+	// Role of the participant that owns the turn (e.g., "assistant", "user")
+	Role string `json:"role"`
+	// Messages in the turn, in transcript order
+	Messages []Message `json:"messages"`
+}
+
+// BuildConversation parses a transcript and groups its messages into turns
+func (p *Parser) BuildConversation(content string) Conversation {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return Conversation{}
+	}
+
+	var conv Conversation
+	closed := true
+	for _, msg := range messages {
+		role := msg.Role
+		// Tool results belong to the assistant turn that requested them
+		if isToolRole(msg.Role) {
+			role = "assistant"
+		}
+
+		last := len(conv.Turns) - 1
+		if last >= 0 && !closed && conv.Turns[last].Role == role {
+			conv.Turns[last].Messages = append(conv.Turns[last].Messages, msg)
+		} else {
+			conv.Turns = append(conv.Turns, Turn{Role: role, Messages: []Message{msg}})
+		}
+		closed = msg.IsReturn
+	}
+	return conv
+}
+
+// Encode renders the conversation in Harmony format. When two consecutive turns
+// have the same role, the first turn's last message is terminated with <|return|>
+// so the boundary survives BuildConversation; calls cannot carry that boundary.
+func (c Conversation) Encode(ts TokenSet) string {
+	var rendered []string
+	for i, turn := range c.Turns {
+		boundary := i+1 < len(c.Turns) && c.Turns[i+1].Role == turn.Role
+		for j, msg := range turn.Messages {
+			if boundary && j == len(turn.Messages)-1 && !msg.IsCall {
+				msg.IsReturn = true
+			}
+			rendered = append(rendered, msg.Render(ts))
+		}
+	}
+	return strings.Join(rendered, "\n")
+}
@@ -0,0 +1,65 @@
+package goharmony
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildConversation(t *testing.T) {
+	parser := NewParser()
+	input := `<|start|>user<|message|>What's the weather in NYC?<|end|>
+<|start|>assistant<|channel|>analysis<|message|>Need weather data<|end|>
+<|start|>assistant<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|start|>assistant<|channel|>final<|message|>It's 72°F.<|return|>
+<|start|>assistant<|channel|>final<|message|>Anything else?<|return|>`
+
+	expected := Conversation{Turns: []Turn{
+		{Role: "user", Messages: []Message{
+			{Role: "user", Channel: ChannelNone, Content: "What's the weather in NYC?"},
+		}},
+		{Role: "assistant", Messages: []Message{
+			{Role: "assistant", Channel: ChannelAnalysis, Content: "Need weather data"},
+			{Role: "assistant", Channel: ChannelCommentary, Content: `{"location": "NYC"}`, To: "functions.get_weather", IsCall: true, ArgsAreJSON: true, Complete: true},
+			{Role: "functions.get_weather", Channel: ChannelCommentary, Content: `{"temperature": 72}`, To: "assistant"},
+			{Role: "assistant", Channel: ChannelFinal, Content: "It's 72°F.", IsReturn: true},
+		}},
+		{Role: "assistant", Messages: []Message{
+			{Role: "assistant", Channel: ChannelFinal, Content: "Anything else?", IsReturn: true},
+		}},
+	}}
+
+	conv := parser.BuildConversation(input)
+	if !reflect.DeepEqual(conv, expected) {
+		t.Errorf("BuildConversation() = %v, want %v", conv, expected)
+	}
+}
+
+func TestConversationEncode(t *testing.T) {
+	parser := NewParser()
+	conv := Conversation{Turns: []Turn{
+		{Role: "user", Messages: []Message{
+			{Role: "user", Channel: ChannelNone, Content: "Weather?"},
+		}},
+		{Role: "assistant", Messages: []Message{
+			{Role: "assistant", Channel: ChannelCommentary, Content: `{"location": "NYC"}`, To: "functions.get_weather", IsCall: true, ArgsAreJSON: true, Complete: true},
+			{Role: "functions.get_weather", Channel: ChannelCommentary, Content: `{"temperature": 72}`, To: "assistant"},
+			{Role: "assistant", Channel: ChannelFinal, Content: "It's 72°F.", IsReturn: true},
+		}},
+		{Role: "assistant", Messages: []Message{
+			{Role: "assistant", Channel: ChannelFinal, Content: "Anything else?", IsReturn: true},
+		}},
+	}}
+
+	encoded := conv.Encode(DefaultTokenSet())
+	if rebuilt := parser.BuildConversation(encoded); !reflect.DeepEqual(rebuilt, conv) {
+		t.Errorf("BuildConversation(Encode()) = %v, want %v", rebuilt, conv)
+	}
+
+	// A boundary between same-role turns is added when missing
+	conv.Turns[1].Messages[2].IsReturn = false
+	rebuilt := parser.BuildConversation(conv.Encode(DefaultTokenSet()))
+	if len(rebuilt.Turns) != len(conv.Turns) {
+		t.Errorf("BuildConversation(Encode()) turns = %d, want %d", len(rebuilt.Turns), len(conv.Turns))
+	}
+}
@@ -0,0 +1,250 @@
+package goharmony
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// TokenSet holds the special tokens used when rendering Harmony messages
+type TokenSet struct {
+	Start     string
+	End       string
+	Channel   string
+	Message   string
+	Call      string
+	Return    string
+	Constrain string
+}
+
+// DefaultTokenSet returns the standard Harmony special tokens
+func DefaultTokenSet() TokenSet {
+	return TokenSet{
+		Start:     "<|start|>",
+		End:       "<|end|>",
+		Channel:   "<|channel|>",
+		Message:   "<|message|>",
+		Call:      "<|call|>",
+		Return:    "<|return|>",
+		Constrain: "<|constrain|>",
+	}
+}
+
+// Render renders a single message in Harmony format using the given token set.
+// The terminator is chosen from the message flags: IsCall uses the call token,
+// IsReturn uses the return token, and anything else uses the end token.
+func (m Message) Render(ts TokenSet) string {
+	var b strings.Builder
+
+	b.WriteString(ts.Start)
+	b.WriteString(m.Role)
+	if m.ToolName != "" {
+		b.WriteString(" name=")
+		b.WriteString(m.ToolName)
+	}
+	if m.CallID != "" {
+		b.WriteString(" tool_call_id=")
+		b.WriteString(m.CallID)
+	}
+	if m.Channel != ChannelNone {
+		b.WriteString(ts.Channel)
+		b.WriteString(string(m.Channel))
+	}
+	if m.To != "" {
+		b.WriteString(" to=")
+		b.WriteString(m.To)
+	}
+	if m.Constrain != "" {
+		b.WriteString(" ")
+		b.WriteString(ts.Constrain)
+		b.WriteString(m.Constrain)
+	}
+	b.WriteString(ts.Message)
+	b.WriteString(m.Content)
+
+	switch {
+	case m.IsCall:
+		b.WriteString(ts.Call)
+	case m.IsReturn:
+		b.WriteString(ts.Return)
+	default:
+		b.WriteString(ts.End)
+	}
+
+	return b.String()
+}
+
+// wordJoiner is inserted between "<" and "|" to neutralize special tokens
+const wordJoiner = "\u2060"
+
+// escapedTokenPattern matches "<|" with any number of word joiners in between
+var escapedTokenPattern = regexp.MustCompile(`<(\x{2060}*)\|`)
+
+// EscapeContent neutralizes Harmony special tokens in untrusted content by
+// inserting an invisible word joiner after each "<" that starts a "<|" sequence.
+// Sequences that were already escaped gain one more joiner, so UnescapeContent
+// restores the original text exactly.
+func EscapeContent(s string) string {
+	return escapedTokenPattern.ReplaceAllString(s, "<${1}"+wordJoiner+"|")
+}
+
+// UnescapeContent reverses EscapeContent
+func UnescapeContent(s string) string {
+	return escapedTokenPattern.ReplaceAllStringFunc(s, func(match string) string {
+		return strings.Replace(match, wordJoiner, "", 1)
+	})
+}
+
+// Encode renders messages in Harmony format using the default token set
+func Encode(messages []Message) string {
+	return EncodeWithTokenSet(messages, DefaultTokenSet())
+}
+
+// EncodeWithTokenSet renders messages in Harmony format using a custom token set
+func EncodeWithTokenSet(messages []Message, ts TokenSet) string {
+	rendered := make([]string, len(messages))
+	for i, msg := range messages {
+		rendered[i] = msg.Render(ts)
+	}
+	return strings.Join(rendered, "\n")
+}
+
+// Canonicalize parses a response in any supported format (full, simplified,
+// legacy FUNCTION_CALL or plain text) and re-encodes it in full Harmony format
+func (p *Parser) Canonicalize(content string) (string, error) {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return "", err
+	}
+	return Encode(messages), nil
+}
+
+// truncationMarker is appended to content shortened by TruncateAnalysis
+const truncationMarker = "…"
+
+// TruncateAnalysis re-encodes a response with each analysis message shortened to
+// at most maxRunes characters followed by an ellipsis. Other channels are left intact.
+// A negative maxRunes is treated as 0.
+func (p *Parser) TruncateAnalysis(content string, maxRunes int) string {
+	if maxRunes < 0 {
+		maxRunes = 0
+	}
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return content
+	}
+
+	for i, msg := range messages {
+		if msg.Channel != ChannelAnalysis {
+			continue
+		}
+		runes := []rune(msg.Content)
+		if len(runes) > maxRunes {
+			messages[i].Content = string(runes[:maxRunes]) + truncationMarker
+		}
+	}
+	return Encode(messages)
+}
+
+// RemoveChannel re-encodes a response with all messages on the given channel removed
+func (p *Parser) RemoveChannel(content string, channel Channel) string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return content
+	}
+
+	kept := messages[:0]
+	for _, msg := range messages {
+		if msg.Channel != channel {
+			kept = append(kept, msg)
+		}
+	}
+	return Encode(kept)
+}
+
+// DedupeFinals re-encodes a response keeping only the first final message with
+// each distinct content, cleaning up outputs where the model repeats itself
+func (p *Parser) DedupeFinals(content string) string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return content
+	}
+
+	seen := make(map[string]bool)
+	kept := messages[:0]
+	for _, msg := range messages {
+		if msg.Channel == ChannelFinal && !msg.IsCall {
+			if seen[msg.Content] {
+				continue
+			}
+			seen[msg.Content] = true
+		}
+		kept = append(kept, msg)
+	}
+	return Encode(kept)
+}
+
+// Compact re-encodes a response as a minimal transcript: empty messages are
+// dropped, call arguments split across messages are joined as with
+// JoinSplitCallArgs, and adjacent non-call messages from the same role on the
+// same channel are merged, keeping the whitespace at each seam.
+func (p *Parser) Compact(content string) (string, error) {
+	parser := p
+	if !p.config.JoinSplitCallArgs {
+		parser = p.Clone(func(c *ParserConfig) { c.JoinSplitCallArgs = true })
+	}
+	r, err := parser.parseWithTimeout(content, -1)
+	if err != nil {
+		return "", err
+	}
+
+	var compacted []Message
+	var lastRaw string
+	for i, msg := range r.messages {
+		if !msg.IsCall && strings.TrimSpace(msg.Content) == "" {
+			continue
+		}
+		if n := len(compacted); n > 0 && canMerge(compacted[n-1], msg) {
+			compacted[n-1].Content += seamWhitespace(lastRaw, r.raw[i]) + msg.Content
+			compacted[n-1].IsReturn = msg.IsReturn
+		} else {
+			compacted = append(compacted, msg)
+		}
+		lastRaw = r.raw[i]
+	}
+	return Encode(compacted), nil
+}
+
+// canMerge reports whether next continues prev, with the same role, channel,
+// recipient and content type and no call or turn boundary between them
+func canMerge(prev, next Message) bool {
+	return !prev.IsCall && !next.IsCall && !prev.IsReturn &&
+		prev.Role == next.Role && prev.Channel == next.Channel &&
+		prev.To == next.To && prev.Constrain == next.Constrain
+}
+
+// seamWhitespace returns the whitespace trimmed from the end of prev and the
+// start of next, which separated the two fragments in the original output
+func seamWhitespace(prev, next string) string {
+	trailing := prev[len(strings.TrimRightFunc(prev, unicode.IsSpace)):]
+	leading := next[:len(next)-len(strings.TrimLeftFunc(next, unicode.IsSpace))]
+	return trailing + leading
+}
+
+// ToJSONL parses a response and writes each message to w as one line of JSON
+func (p *Parser) ToJSONL(content string, w io.Writer) error {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, msg := range messages {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package goharmony
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrParseTimeout is returned when parsing exceeds ParserConfig.ParseTimeout
+var ErrParseTimeout = errors.New("parse timed out")
+
+// ErrJSONTooDeep is returned by ExtractJSON when JSON nesting exceeds
+// ParserConfig.MaxJSONDepth
+var ErrJSONTooDeep = errors.New("JSON nesting too deep")
+
+// ParseErrorKind classifies why a response failed strict validation
+type ParseErrorKind string
+
+const (
+	// ParseErrorInvalidChannel is reported for channels outside the standard set
+	ParseErrorInvalidChannel ParseErrorKind = "invalid channel"
+	// ParseErrorUnterminated is reported for messages missing their terminator
+	ParseErrorUnterminated ParseErrorKind = "unterminated message"
+	// ParseErrorTrailingContent is reported for text following a turn-ending <|return|>
+	ParseErrorTrailingContent ParseErrorKind = "trailing content"
+	// ParseErrorInvalidArgs is reported for tool calls whose arguments are not valid JSON
+	ParseErrorInvalidArgs ParseErrorKind = "invalid call arguments"
+	// ParseErrorInvalidRecipient is reported for recipients rejected by RecipientValidator
+	ParseErrorInvalidRecipient ParseErrorKind = "invalid recipient"
+	// ParseErrorContentTooLong is reported for content exceeding MaxChannelContent
+	ParseErrorContentTooLong ParseErrorKind = "content too long"
+	// ParseErrorMisrouted is reported by ValidateRouting for messages addressed
+	// to the wrong participant
+	ParseErrorMisrouted ParseErrorKind = "misrouted message"
+)
+
+// ParseError describes a strict-mode validation failure
+type ParseError struct {
+	// Kind of validation failure
+	Kind ParseErrorKind
+	// Offset is the byte offset of the offending message in the input
+	Offset int
+	// Detail describes the offending value
+	Detail string
+	// Err is the underlying cause, if any
+	Err error
+}
+
+// Error implements the error interface
+func (e *ParseError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Kind, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Detail)
+}
+
+// Unwrap returns the underlying cause
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,251 @@
+package goharmony
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrictMode_Unterminated(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictMode = true
+	parser := NewParserWithConfig(config)
+
+	tests := []struct {
+		name         string
+		input        string
+		expectedKind ParseErrorKind
+	}{
+		{
+			name:  "Terminated final",
+			input: `<|channel|>analysis<|message|>Thinking<|end|><|channel|>final<|message|>Hello<|end|>`,
+		},
+		{
+			name:  "Terminated call and return",
+			input: `<|channel|>commentary to=functions.x<|message|>{}<|call|><|channel|>final<|message|>Done<|return|>`,
+		},
+		{
+			name:         "Unterminated final",
+			input:        `<|channel|>analysis<|message|>Thinking<|end|><|channel|>final<|message|>Hel`,
+			expectedKind: ParseErrorUnterminated,
+		},
+		{
+			name:         "Invalid channel",
+			input:        `<|channel|>invalid<|message|>Test<|end|>`,
+			expectedKind: ParseErrorInvalidChannel,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.ParseResponse(tt.input)
+			if tt.expectedKind == "" {
+				if err != nil {
+					t.Errorf("ParseResponse() unexpected error: %v", err)
+				}
+				return
+			}
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseResponse() error = %v, want *ParseError", err)
+			}
+			if parseErr.Kind != tt.expectedKind {
+				t.Errorf("ParseError.Kind = %v, want %v", parseErr.Kind, tt.expectedKind)
+			}
+		})
+	}
+}
+
+func TestStrictMode_TrailingContent(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictMode = true
+	parser := NewParserWithConfig(config)
+
+	if _, err := parser.ParseResponse("<|channel|>final<|message|>Done<|return|>\n  \n"); err != nil {
+		t.Errorf("ParseResponse() unexpected error for trailing whitespace: %v", err)
+	}
+
+	input := `<|channel|>final<|message|>Done<|return|>garbage`
+	var parseErr *ParseError
+	if _, err := parser.ParseResponse(input); !errors.As(err, &parseErr) {
+		t.Fatalf("ParseResponse() error = %v, want *ParseError", err)
+	}
+	if parseErr.Kind != ParseErrorTrailingContent || parseErr.Offset != len(input)-len("garbage") || parseErr.Detail != "garbage" {
+		t.Errorf("ParseResponse() error = %+v, want trailing content at %d", parseErr, len(input)-len("garbage"))
+	}
+
+	// Lenient mode keeps accepting the extra text
+	if _, err := NewParser().ParseResponse(input); err != nil {
+		t.Errorf("ParseResponse() lenient error = %v", err)
+	}
+}
+
+func TestStrictMode_InvalidArgs(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictMode = true
+	parser := NewParserWithConfig(config)
+
+	tests := []struct {
+		name        string
+		input       string
+		expectError bool
+	}{
+		{
+			name:  "Valid JSON args",
+			input: `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`,
+		},
+		{
+			name:  "Non-JSON constrain type",
+			input: `<|channel|>commentary to=functions.run <|constrain|>python<|message|>print("hi")<|call|>`,
+		},
+		{
+			name:        "Malformed args",
+			input:       `<|channel|>commentary to=functions.get_weather<|message|>{"location": NYC}<|call|>`,
+			expectError: true,
+		},
+		{
+			name:  "Legacy call without args",
+			input: `FUNCTION_CALL: test()`,
+		},
+		{
+			name:  "Legacy key=value args",
+			input: `FUNCTION_CALL: search(query="news")`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.ParseResponse(tt.input)
+			if !tt.expectError {
+				if err != nil {
+					t.Errorf("ParseResponse() unexpected error: %v", err)
+				}
+				return
+			}
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseResponse() error = %v, want *ParseError", err)
+			}
+			if parseErr.Kind != ParseErrorInvalidArgs || parseErr.Detail != "get_weather" {
+				t.Errorf("ParseResponse() error = %+v, want invalid args for get_weather", parseErr)
+			}
+		})
+	}
+
+	// Legacy calls are not required to carry JSON arguments
+	if name, args, found := parser.ExtractFunctionCall(`FUNCTION_CALL: test()`); name != "test" || args != "" || !found {
+		t.Errorf("ExtractFunctionCall() = (%q, %q, %v), want (test, \"\", true)", name, args, found)
+	}
+}
+
+func TestStrictMode_MaxChannelContent(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictMode = true
+	config.MaxChannelContent = map[Channel]int{ChannelFinal: 8, ChannelAnalysis: 20}
+	parser := NewParserWithConfig(config)
+
+	tests := []struct {
+		name           string
+		input          string
+		expectedDetail string
+	}{
+		{
+			name:  "Within limits",
+			input: `<|channel|>analysis<|message|>Short thought<|end|><|channel|>final<|message|>Hi there<|end|>`,
+		},
+		{
+			name:  "Unlimited channel",
+			input: `<|channel|>commentary<|message|>Commentary has no limit configured<|end|>`,
+		},
+		{
+			name:           "Final over limit",
+			input:          `<|channel|>final<|message|>Hello there<|end|>`,
+			expectedDetail: "final has 11 bytes, limit 8",
+		},
+		{
+			name:           "Analysis over limit",
+			input:          `<|channel|>analysis<|message|>This thought is far too long<|end|>`,
+			expectedDetail: "analysis has 28 bytes, limit 20",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.ParseResponse(tt.input)
+			if tt.expectedDetail == "" {
+				if err != nil {
+					t.Errorf("ParseResponse() unexpected error: %v", err)
+				}
+				return
+			}
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseResponse() error = %v, want *ParseError", err)
+			}
+			if parseErr.Kind != ParseErrorContentTooLong || parseErr.Detail != tt.expectedDetail {
+				t.Errorf("ParseResponse() error = %+v, want %q", parseErr, tt.expectedDetail)
+			}
+		})
+	}
+}
+
+func TestRecipientValidator(t *testing.T) {
+	errPolicy := errors.New("recipient must be in the functions namespace")
+	config := DefaultConfig()
+	config.RecipientValidator = func(to string) error {
+		if !strings.HasPrefix(to, "functions.") {
+			return errPolicy
+		}
+		return nil
+	}
+	input := `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>commentary to=browser.open<|message|>{"url": "x"}<|call|>`
+
+	messages, issues, err := NewParserWithConfig(config).ParseResponseWithIssues(input)
+	if err != nil {
+		t.Fatalf("ParseResponseWithIssues() error = %v", err)
+	}
+	if len(messages) != 2 || len(issues) != 1 || issues[0].Detail != "browser.open" || !errors.Is(&issues[0], errPolicy) {
+		t.Errorf("ParseResponseWithIssues() = %v, %v, want 2 messages and one browser.open issue", messages, issues)
+	}
+
+	config.StrictMode = true
+	_, err = NewParserWithConfig(config).ParseResponse(input)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Kind != ParseErrorInvalidRecipient || !errors.Is(err, errPolicy) {
+		t.Errorf("ParseResponse() error = %v, want invalid recipient", err)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.ParseTimeout = time.Microsecond
+	parser := NewParserWithConfig(config)
+
+	slow := strings.Repeat("<|channel|>analysis to=functions.x <|constrain|>json", 20000)
+	if _, err := parser.ParseResponse(slow); !errors.Is(err, ErrParseTimeout) {
+		t.Errorf("ParseResponse() error = %v, want ErrParseTimeout", err)
+	}
+
+	parser = parser.Clone(func(c *ParserConfig) { c.ParseTimeout = time.Minute })
+	messages, err := parser.ParseResponse(`<|channel|>final<|message|>Hello<|end|>`)
+	if err != nil || len(messages) != 1 {
+		t.Errorf("ParseResponse() = %v, %v, want one message", messages, err)
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	err := &ParseError{Kind: ParseErrorInvalidChannel, Detail: "invalid"}
+	if err.Error() != "invalid channel: invalid" {
+		t.Errorf("Error() = %v, want %v", err.Error(), "invalid channel: invalid")
+	}
+
+	err = &ParseError{Kind: ParseErrorInvalidRecipient, Detail: "x.y", Err: errors.New("denied")}
+	if err.Error() != "invalid recipient: x.y: denied" {
+		t.Errorf("Error() = %v, want %v", err.Error(), "invalid recipient: x.y: denied")
+	}
+}
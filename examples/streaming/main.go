@@ -42,40 +42,20 @@ func main() {
 	stream := simulateStream()
 	
 	var buffer strings.Builder
-	var lastFinalContent string
+	started := false
 	
 	fmt.Println("Receiving stream...")
 	for chunk := range stream {
+		previous := buffer.String()
 		buffer.WriteString(chunk)
-		currentContent := buffer.String()
 		
-		// Try to parse what we have so far
-		messages, err := parser.ParseResponse(currentContent)
-		if err != nil {
-			continue // Wait for more data
-		}
-		
-		// Look for final channel content
-		for _, msg := range messages {
-			if msg.Channel == goharmony.ChannelFinal {
-				// Check if we have new content
-				if msg.Content != lastFinalContent && msg.Content != "" {
-					// Display incremental content
-					if lastFinalContent == "" {
-						fmt.Print("User sees: ")
-					}
-					
-					// Show only the new part
-					if strings.HasPrefix(msg.Content, lastFinalContent) {
-						newPart := msg.Content[len(lastFinalContent):]
-						fmt.Print(newPart)
-					} else {
-						fmt.Print(msg.Content)
-					}
-					
-					lastFinalContent = msg.Content
-				}
+		// Show only the final-channel text added by this chunk
+		if delta := parser.FinalDelta(previous, buffer.String()); delta != "" {
+			if !started {
+				fmt.Print("User sees: ")
+				started = true
 			}
+			fmt.Print(delta)
 		}
 	}
 	fmt.Println("\n\nStream complete!")
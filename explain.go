@@ -0,0 +1,67 @@
+package goharmony
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explain returns a human-readable trace of how a response is parsed: the
+// detected format, which pattern or fallback produced the messages, and how
+// each message was classified. It is meant for debugging unexpected output.
+func (p *Parser) Explain(content string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "format: %s\n", p.DetectFormat(content))
+	fmt.Fprintf(&b, "input: %d bytes\n", len(content))
+
+	r, err := p.parseWithTimeout(content, -1)
+	if err != nil {
+		fmt.Fprintf(&b, "error: %v\n", err)
+		return b.String()
+	}
+
+	sanitized := sanitizeInput(content)
+	if len(sanitized) < len(content) {
+		fmt.Fprintf(&b, "sanitized: removed %d leading bytes\n", len(content)-len(sanitized))
+	}
+	if len(r.text) < len(sanitized) {
+		b.WriteString("sanitized: removed surrounding code fence\n")
+	}
+
+	if r.pattern == "" {
+		b.WriteString("matched: nothing\n")
+	} else {
+		fmt.Fprintf(&b, "matched: %s\n", r.pattern)
+	}
+
+	prefix := len(content) - len(r.text)
+	for i, msg := range r.messages {
+		fmt.Fprintf(&b, "message %d [bytes %d-%d]: %s/%s, %s, %d bytes of content\n",
+			i, r.spans[i][0]+prefix, r.spans[i][1]+prefix, msg.Role, msg.Channel, classify(msg), len(msg.Content))
+	}
+	for _, issue := range r.issues {
+		fmt.Fprintf(&b, "issue: %v\n", &issue)
+	}
+	return b.String()
+}
+
+// classify describes the role a message plays in a response
+func classify(msg Message) string {
+	switch {
+	case msg.IsCall && !msg.ArgsAreJSON:
+		return fmt.Sprintf("tool call to %s with non-JSON arguments", msg.To)
+	case msg.IsCall:
+		return fmt.Sprintf("tool call to %s", msg.To)
+	case isToolRole(msg.Role):
+		return fmt.Sprintf("tool result from %s", msg.Role)
+	case msg.Channel == ChannelFinal && msg.IsReturn:
+		return "final answer ending the turn"
+	case msg.Channel == ChannelFinal:
+		return "final answer"
+	case msg.Channel == ChannelAnalysis:
+		return "reasoning"
+	case msg.Channel == ChannelCommentary:
+		return "commentary"
+	default:
+		return "message without a channel"
+	}
+}
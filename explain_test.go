@@ -0,0 +1,57 @@
+package goharmony
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+	}{
+		{
+			name:  "Plain text",
+			input: "Just a plain answer",
+			contains: []string{
+				"format: plain",
+				"matched: plain text fallback",
+				"message 0 [bytes 0-19]: assistant/final, final answer, 19 bytes of content",
+			},
+		},
+		{
+			name: "Full format",
+			input: `<|channel|>analysis<|message|>Need data<|end|>
+<|channel|>commentary to=functions.search<|message|>{"q": "news"}<|call|>`,
+			contains: []string{
+				"format: harmony_simple",
+				"matched: full message pattern",
+				"message 0 [bytes 0-46]: assistant/analysis, reasoning, 9 bytes of content",
+				"message 1 [bytes 47-120]: assistant/commentary, tool call to functions.search, 13 bytes of content",
+			},
+		},
+		{
+			name:  "Legacy call",
+			input: "\uFEFFFUNCTION_CALL: search(query=news)",
+			contains: []string{
+				"sanitized: removed 3 leading bytes",
+				"matched: FUNCTION_CALL fallback",
+				"tool call to functions.search with non-JSON arguments",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			explanation := parser.Explain(tt.input)
+			for _, want := range tt.contains {
+				if !strings.Contains(explanation, want) {
+					t.Errorf("Explain() = %q, want it to contain %q", explanation, want)
+				}
+			}
+		})
+	}
+}
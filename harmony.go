@@ -6,8 +6,12 @@ package goharmony
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Channel represents different message channels in the Harmony format
@@ -20,6 +24,72 @@ const (
 	ChannelCommentary Channel = "commentary"
 	// ChannelFinal is for user-facing responses
 	ChannelFinal Channel = "final"
+	// ChannelNone is used for messages without a channel (e.g., user or system turns)
+	ChannelNone Channel = ""
+)
+
+// AllChannels returns the standard Harmony channels
+func AllChannels() []Channel {
+	return []Channel{ChannelAnalysis, ChannelCommentary, ChannelFinal}
+}
+
+// DisplayName returns a human-readable name for the channel
+func (c Channel) DisplayName() string {
+	switch c {
+	case ChannelAnalysis:
+		return "Analysis"
+	case ChannelCommentary:
+		return "Commentary"
+	case ChannelFinal:
+		return "Final"
+	case ChannelNone:
+		return "None"
+	default:
+		return string(c)
+	}
+}
+
+// IsUserVisible reports whether content on the channel is meant for end users
+func (c Channel) IsUserVisible() bool {
+	return c == ChannelFinal
+}
+
+// Format identifies which response format variant was used
+type Format string
+
+const (
+	// FormatHarmonyFull is Harmony with <|start|> role headers
+	FormatHarmonyFull Format = "harmony_full"
+	// FormatHarmonySimple is Harmony channel blocks without <|start|> headers
+	FormatHarmonySimple Format = "harmony_simple"
+	// FormatFunctionCall is the legacy FUNCTION_CALL: name(args) format
+	FormatFunctionCall Format = "function_call"
+	// FormatPlain is unstructured plain text
+	FormatPlain Format = "plain"
+)
+
+// ResponseKind describes the action a response asks the caller to take
+type ResponseKind string
+
+const (
+	// KindToolCall means the response requests a tool call
+	KindToolCall ResponseKind = "tool_call"
+	// KindFinalAnswer means the response contains a user-facing answer
+	KindFinalAnswer ResponseKind = "final_answer"
+	// KindReasoningOnly means the response only contains analysis or commentary
+	KindReasoningOnly ResponseKind = "reasoning_only"
+	// KindEmpty means the response contains nothing actionable
+	KindEmpty ResponseKind = "empty"
+)
+
+// SummaryStrategy selects which analysis message ExtractReasoningSummary returns
+type SummaryStrategy string
+
+const (
+	// SummaryLast picks the last analysis message
+	SummaryLast SummaryStrategy = "last"
+	// SummaryShortest picks the shortest analysis message
+	SummaryShortest SummaryStrategy = "shortest"
 )
 
 // Message represents a parsed message from Harmony format
@@ -32,15 +102,35 @@ type Message struct {
 	Content string `json:"content"`
 	// To field for function calls (e.g., "functions.get_weather")
 	To string `json:"to,omitempty"`
+	// Constrain is the content type declared via <|constrain|> (e.g., "json")
+	Constrain string `json:"constrain,omitempty"`
+	// ToolName and CallID are the name= and tool_call_id= attributes of a tool
+	// message header (e.g., "<|start|>tool name=get_weather tool_call_id=call_0")
+	ToolName string `json:"tool_name,omitempty"`
+	CallID   string `json:"tool_call_id,omitempty"`
 	// IsCall indicates whether this is a function/tool call
 	IsCall bool `json:"is_call,omitempty"`
+	// ArgsAreJSON indicates whether a call's content is valid JSON arguments
+	ArgsAreJSON bool `json:"args_are_json,omitempty"`
+	// Complete indicates a call's arguments are usable. It is false only when
+	// RequireCompleteCallArgs is set and the arguments are not complete JSON.
+	Complete bool `json:"complete,omitempty"`
+	// IsReturn indicates whether the message was terminated by <|return|>
+	IsReturn bool `json:"is_return,omitempty"`
+	// Timestamp annotation preceding the message, if configured
+	Timestamp string `json:"timestamp,omitempty"`
+	// Start and End are the byte offsets of the message in the input, if configured
+	Start int `json:"start,omitempty"`
+	End   int `json:"end,omitempty"`
+	// StartRune and EndRune are the character offsets of the message, if configured
+	StartRune int `json:"start_rune,omitempty"`
+	EndRune   int `json:"end_rune,omitempty"`
 }
 
 // Parser handles parsing of OpenAI Harmony format responses
 type Parser struct {
 	// Regex patterns for parsing
 	messagePattern  *regexp.Regexp
-	channelPattern  *regexp.Regexp
 	functionPattern *regexp.Regexp
 	// Configuration options
 	config ParserConfig
@@ -52,13 +142,85 @@ type ParserConfig struct {
 	StrictMode bool
 	// DefaultRole is the default role when not specified
 	DefaultRole string
+	// DefaultChannel is the channel assigned to messages that omit <|channel|>
+	DefaultChannel Channel
+	// Tolerant allows stray whitespace and newlines inside message headers,
+	// such as between <|channel|> and the channel name
+	Tolerant bool
+	// NormalizeRecipients lowercases the namespace of each recipient (e.g.,
+	// "Functions.GetWeather" becomes "functions.GetWeather"). Recipients without
+	// a namespace, such as "Assistant", are lowercased entirely.
+	NormalizeRecipients bool
+	// LowercaseFunctionNames also lowercases the function name when
+	// NormalizeRecipients is set. Function names are case-sensitive otherwise.
+	LowercaseFunctionNames bool
+	// JoinSplitCallArgs merges consecutive commentary messages addressed to a
+	// tool into a single call when only the last one ends with <|call|>
+	JoinSplitCallArgs bool
+	// KVCallArgs converts legacy FUNCTION_CALL arguments written as key=value
+	// pairs (e.g., search(query="news", limit=5)) into a JSON object with string
+	// values. Arguments that are already JSON are left untouched.
+	KVCallArgs bool
+	// StripCodeFences removes surrounding ``` fences (with optional language tag)
+	StripCodeFences bool
+	// ChannelAliases maps nonstandard channel keywords (e.g., "final_v2") to
+	// channels during parsing, before strict validation
+	ChannelAliases map[string]Channel
+	// SummaryStrategy selects the analysis message used by ExtractReasoningSummary.
+	// The zero value behaves like SummaryLast.
+	SummaryStrategy SummaryStrategy
+	// CitationPattern matches citation markers in final content. When nil,
+	// markers like 【1】, 【3†source】 and [1] are recognized.
+	CitationPattern *regexp.Regexp
+	// TimestampPattern, when set, extracts a timestamp annotation preceding
+	// each message (e.g., "[2024-01-01T00:00:00Z]"). If the pattern has a
+	// capture group, the first group is used as the timestamp value.
+	TimestampPattern *regexp.Regexp
+	// RequireCompleteCallArgs marks calls whose arguments are not complete JSON
+	// as incomplete so they are skipped by ExtractFunctionCall
+	RequireCompleteCallArgs bool
+	// SuppressJSONFinal makes ExtractFinalMessage skip final messages that are
+	// entirely a JSON object or array, such as leaked tool arguments
+	SuppressJSONFinal bool
+	// SynthesizeFinalFromAnalysis makes ExtractFinalMessage return a stand-in when
+	// a response has analysis but no final message: SynthesizedFinal if set,
+	// otherwise the last analysis content
+	SynthesizeFinalFromAnalysis bool
+	// SynthesizedFinal is the placeholder used by SynthesizeFinalFromAnalysis
+	SynthesizedFinal string
+	// ParseTimeout bounds the time spent matching a single response.
+	// Zero means no limit.
+	ParseTimeout time.Duration
+	// RecipientValidator, when set, is called for each parsed recipient. An error
+	// aborts parsing in strict mode and is reported by ParseResponseWithIssues otherwise.
+	RecipientValidator func(string) error
+	// MaxChannelContent limits the content length in bytes per channel.
+	// Longer messages are rejected in strict mode.
+	MaxChannelContent map[Channel]int
+	// RecordOffsets sets Start and End on each message. Offsets refer to the
+	// input as passed in, including any text removed by StripCodeFences or
+	// masked by ParseResponseOpaque.
+	RecordOffsets bool
+	// RecordRuneOffsets also sets StartRune and EndRune, at the cost of an
+	// extra scan of the input
+	RecordRuneOffsets bool
+	// MaxJSONDepth, when positive, limits how deeply objects and arrays may nest
+	// in JSON decoded by ExtractJSON. Deeper input fails with ErrJSONTooDeep.
+	MaxJSONDepth int
+	// StripRolePrefix removes a leading echo of the message's role (e.g.,
+	// "assistant: Hello" becomes "Hello") from final content
+	StripRolePrefix bool
+	// ContentTransform, when set, is applied to each message's content before
+	// it is returned, e.g. to unescape HTML in final messages only
+	ContentTransform func(Channel, string) string
 }
 
 // DefaultConfig returns the default parser configuration
 func DefaultConfig() ParserConfig {
 	return ParserConfig{
-		StrictMode:  false,
-		DefaultRole: "assistant",
+		StrictMode:     false,
+		DefaultRole:    "assistant",
+		DefaultChannel: ChannelNone,
 	}
 }
 
@@ -70,93 +232,354 @@ func NewParser() *Parser {
 // NewParserWithConfig creates a new Harmony format parser with custom configuration
 func NewParserWithConfig(config ParserConfig) *Parser {
 	return &Parser{
-		// Match messages with optional start tag and optional end tag
-		messagePattern: regexp.MustCompile(
-			`(?s)(?:<\|start\|>)?(\w+)?<\|channel\|>(\w+)(?:\s+to=([\w.]+))?` +
-				`(?:\s*<\|constrain\|>\w+)?<\|message\|>(.*?)(?:<\|(?:end|call|return)\|>|$)`,
-		),
-		// Match standalone channel markers
-		channelPattern: regexp.MustCompile(
-			`<\|channel\|>(\w+)<\|message\|>(.*?)(?:<\|end\|>|$)`,
-		),
+		messagePattern: compileMessagePattern(config.Tolerant),
 		// Match function calls in various formats
 		functionPattern: regexp.MustCompile(
-			`FUNCTION_CALL:\s*(\w+)\((.*?)\)`,
+			`FUNCTION_CALL:\s*(?P<name>\w+)\((?P<args>.*?)\)`,
 		),
 		config: config,
 	}
 }
 
-// ParseResponse parses a Harmony formatted response into structured messages
+// Clone returns a copy of the parser with its configuration modified by modify.
+// Map fields are copied so modify cannot change the original's configuration.
+// Patterns that do not depend on a changed field are shared with the original.
+func (p *Parser) Clone(modify func(*ParserConfig)) *Parser {
+	clone := *p
+	clone.config.ChannelAliases = maps.Clone(p.config.ChannelAliases)
+	clone.config.MaxChannelContent = maps.Clone(p.config.MaxChannelContent)
+	if modify != nil {
+		modify(&clone.config)
+	}
+	if clone.config.Tolerant != p.config.Tolerant {
+		clone.messagePattern = compileMessagePattern(clone.config.Tolerant)
+	}
+	return &clone
+}
+
+// compileMessagePattern builds the Harmony message pattern, which covers both
+// full messages and bare simplified channel blocks. A message needs either a
+// <|start|> header or a <|channel|> keyword, so a stray <|message|> token cannot
+// start a match. The channel is optional after <|start|>. Any role may follow
+// <|start|>, but only a standard Harmony role may directly precede a bare
+// <|channel|>, so a stray word before the channel is left outside the match.
+// Role and channel keywords may be wrapped in quotes, which are dropped. The
+// role may carry name= and tool_call_id= attributes, as tool messages do.
+// Whitespace is always allowed around the constrain keyword so it never leaks
+// into the content; in tolerant mode it is also allowed around the other header
+// keywords.
+func compileMessagePattern(tolerant bool) *regexp.Regexp {
+	ws := ""
+	if tolerant {
+		ws = `\s*`
+	}
+	attrs := `["']?(?P<attrs>(?:\s+(?:name|tool_call_id)=[\w.:-]+)*)(?:\s+to=(?P<role_to>[\w.]+))?` + ws
+	role := `["']?(?P<role>[\w.]+)` + attrs
+	bareRole := `["']?\b(?P<role>system|developer|user|assistant|tool)` + attrs
+	channel := `<\|channel\|>` + ws + `["']?(?P<channel>\w+)["']?`
+	return regexp.MustCompile(
+		`(?s)(?:<\|start\|>` + ws + `(?:` + role + `)?(?:` + channel + `)?|(?:` + bareRole + `)?` + channel + `)` +
+			`(?:\s+to=(?P<to>[\w.]+))?(?:\s*<\|constrain\|>\s*(?P<constrain>\w+)\s*)?` + ws +
+			`<\|message\|>(?P<content>.*?)(?P<terminator><\|(?:end|call|return)\|>|$)`,
+	)
+}
+
+// ParseResponse parses a Harmony formatted response into structured messages.
+// A role must normally follow <|start|>; a bare role word directly before
+// <|channel|> is only accepted when it is a standard Harmony role (system,
+// developer, user, assistant, tool), otherwise the default role is used.
 func (p *Parser) ParseResponse(content string) ([]Message, error) {
-	if content == "" {
+	r, err := p.parseWithTimeout(content, -1)
+	return r.messages, err
+}
+
+// ParseResponseLimit parses at most n messages, without scanning the input
+// past the n-th message
+func (p *Parser) ParseResponseLimit(content string, n int) ([]Message, error) {
+	if n <= 0 {
 		return nil, nil
 	}
+	r, err := p.parseWithTimeout(content, n)
+	return r.messages, err
+}
+
+// ParseResponseOpaque parses a response whose messages may embed Harmony samples,
+// such as few-shot examples. ParseResponse would split such samples into messages
+// of their own; here every region between a pair of fenceToken markers is kept
+// verbatim, fences included, inside the message that contains it. An unpaired
+// trailing fence is left as ordinary text. Fenced regions cannot be nested.
+// Recorded offsets refer to content, with fenced regions at their full length.
+func (p *Parser) ParseResponseOpaque(content, fenceToken string) ([]Message, error) {
+	if fenceToken == "" {
+		return p.ParseResponse(content)
+	}
+
+	var masked strings.Builder
+	var restore []string
+	var shifts []maskShift
+	rest := content
+	for {
+		start := strings.Index(rest, fenceToken)
+		if start == -1 {
+			break
+		}
+		inner := strings.Index(rest[start+len(fenceToken):], fenceToken)
+		if inner == -1 {
+			break
+		}
+		end := start + len(fenceToken) + inner + len(fenceToken)
+
+		placeholder := fmt.Sprintf("\uE000%d\uE001", len(restore)/2)
+		restore = append(restore, placeholder, rest[start:end])
+		masked.WriteString(rest[:start])
+		masked.WriteString(placeholder)
+
+		var prev maskShift
+		if len(shifts) > 0 {
+			prev = shifts[len(shifts)-1]
+		}
+		shifts = append(shifts, maskShift{
+			end:   masked.Len(),
+			bytes: prev.bytes + end - start - len(placeholder),
+			runes: prev.runes + utf8.RuneCountInString(rest[start:end]) - utf8.RuneCountInString(placeholder),
+		})
+		rest = rest[end:]
+	}
+	masked.WriteString(rest)
+
+	messages, err := p.ParseResponse(masked.String())
+	if err != nil || len(restore) == 0 {
+		return messages, err
+	}
+
+	replacer := strings.NewReplacer(restore...)
+	for i := range messages {
+		messages[i].Content = replacer.Replace(messages[i].Content)
+		if p.config.RecordOffsets || p.config.RecordRuneOffsets {
+			p.unmaskOffsets(&messages[i], shifts)
+		}
+	}
+	return messages, nil
+}
+
+// maskShift records where a ParseResponseOpaque placeholder ends in the masked
+// text and how far later offsets move, in bytes and runes, once it and the
+// placeholders before it are expanded
+type maskShift struct {
+	end, bytes, runes int
+}
+
+// unmaskOffsets moves a message's offsets in the masked text past the expanded
+// regions that precede them. Messages never start or end inside a placeholder.
+func (p *Parser) unmaskOffsets(msg *Message, shifts []maskShift) {
+	shift := func(offset int) maskShift {
+		var s maskShift
+		for _, next := range shifts {
+			if next.end > offset {
+				break
+			}
+			s = next
+		}
+		return s
+	}
+
+	start, end := shift(msg.Start), shift(msg.End)
+	msg.Start += start.bytes
+	msg.End += end.bytes
+	if p.config.RecordRuneOffsets {
+		msg.StartRune += start.runes
+		msg.EndRune += end.runes
+	}
+}
+
+// ParseResponseWithIssues parses a response like ParseResponse and also returns
+// the non-fatal issues found in lenient mode, such as rejected recipients
+func (p *Parser) ParseResponseWithIssues(content string) ([]Message, []ParseError, error) {
+	r, err := p.parseWithTimeout(content, -1)
+	return r.messages, r.issues, err
+}
+
+// ParseMultiple splits content on separator and parses each response independently.
+// Empty or whitespace-only segments are skipped.
+func (p *Parser) ParseMultiple(content, separator string) ([][]Message, error) {
+	var responses [][]Message
+	for i, segment := range strings.Split(content, separator) {
+		if strings.TrimSpace(segment) == "" {
+			continue
+		}
+		messages, err := p.ParseResponse(segment)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		responses = append(responses, messages)
+	}
+	return responses, nil
+}
+
+// parseWithTimeout runs parse within the configured ParseTimeout, if any.
+// On timeout the matching goroutine is abandoned and finishes in the background.
+func (p *Parser) parseWithTimeout(content string, limit int) (parsed, error) {
+	if p.config.ParseTimeout <= 0 {
+		return p.parse(content, limit)
+	}
+
+	type result struct {
+		parsed parsed
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, err := p.parse(content, limit)
+		done <- result{r, err}
+	}()
+
+	timer := time.NewTimer(p.config.ParseTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.parsed, r.err
+	case <-timer.C:
+		return parsed{}, fmt.Errorf("%w after %s", ErrParseTimeout, p.config.ParseTimeout)
+	}
+}
+
+// parsed holds parsed messages with their spans in the normalized input text
+// and any non-fatal issues found in lenient mode
+type parsed struct {
+	messages []Message
+	spans    [][2]int
+	// raw holds each message's untrimmed content as it appears in the text
+	raw    []string
+	text   string
+	issues []ParseError
+	// pattern describes which pattern or fallback produced the messages
+	pattern string
+}
+
+// parse parses up to limit messages, or all messages if limit is negative,
+// recording where each message appears in the normalized input
+func (p *Parser) parse(content string, limit int) (parsed, error) {
+	if content == "" {
+		return parsed{}, nil
+	}
+
+	original := content
+	content = sanitizeInput(content)
+	offset := len(original) - len(content)
+	if p.config.StripCodeFences {
+		var fence int
+		content, fence = stripCodeFences(content)
+		offset += fence
+	}
 
 	var messages []Message
 
 	// First, try to parse full Harmony format messages
-	matches := p.messagePattern.FindAllStringSubmatch(content, -1)
-	for _, match := range matches {
+	var raw []string
+	var spans [][2]int
+	prevEnd := 0
+	for _, loc := range p.messagePattern.FindAllStringSubmatchIndex(content, limit) {
+		match := p.matchMessage(content, loc)
 		msg := Message{}
 		
-		// match[1] = role (if present)
-		// match[2] = channel
-		// match[3] = to (if present)
-		// match[4] = content
-		
-		if match[1] != "" {
-			msg.Role = match[1]
+		if match.role != "" {
+			msg.Role = match.role
 		} else {
 			msg.Role = p.config.DefaultRole
 		}
 		
-		msg.Channel = Channel(match[2])
-		msg.To = match[3]
-		msg.Content = strings.TrimSpace(match[4])
+		msg.Channel = p.resolveChannel(match.channel)
+		if match.channel == "" {
+			msg.Channel = p.config.DefaultChannel
+		}
+		msg.To = match.to
+		if msg.To == "" {
+			msg.To = match.roleTo
+		}
+		msg.To = p.normalizeRecipient(msg.To)
+		msg.Constrain = match.constrain
+		msg.ToolName, msg.CallID = parseToolAttrs(match.attrs)
+		msg.Content = strings.TrimSpace(match.content)
 		
-		// Check if this is a function call
-		if strings.Contains(match[0], "<|call|>") {
+		// Extract a timestamp annotation preceding the message
+		if p.config.TimestampPattern != nil {
+			msg.Timestamp = p.trailingTimestamp(content[prevEnd:loc[0]])
+			if msg.Timestamp == "" {
+				msg.Timestamp, msg.Content = p.leadingTimestamp(msg.Content)
+			}
+		}
+		prevEnd = loc[1]
+		
+		// Check how the message was terminated
+		switch match.terminator {
+		case "<|call|>":
 			msg.IsCall = true
+			msg.ArgsAreJSON = json.Valid([]byte(msg.Content))
+		case "<|return|>":
+			msg.IsReturn = true
 		}
 		
-		// Validate channel in strict mode
-		if p.config.StrictMode && !p.isValidChannel(msg.Channel) {
-			return nil, fmt.Errorf("invalid channel: %s", msg.Channel)
+		// Validate explicit channels and terminators in strict mode
+		if p.config.StrictMode && match.channel != "" && !p.isValidChannel(msg.Channel) {
+			return parsed{}, &ParseError{Kind: ParseErrorInvalidChannel, Offset: loc[0], Detail: string(msg.Channel)}
+		}
+		if p.config.StrictMode && match.terminator == "" {
+			return parsed{}, &ParseError{Kind: ParseErrorUnterminated, Offset: loc[0], Detail: string(msg.Channel)}
+		}
+		if p.config.StrictMode && msg.IsReturn {
+			if trailing := strings.TrimSpace(content[loc[1]:]); trailing != "" {
+				return parsed{}, &ParseError{Kind: ParseErrorTrailingContent, Offset: loc[1], Detail: trailing}
+			}
 		}
 		
 		messages = append(messages, msg)
+		raw = append(raw, match.content)
+		spans = append(spans, [2]int{loc[0], loc[1]})
 	}
 
-	// If no full format found, try simplified channel format
-	if len(messages) == 0 && strings.Contains(content, "<|channel|>") {
-		matches = p.channelPattern.FindAllStringSubmatch(content, -1)
-		for _, match := range matches {
-			msg := Message{
-				Role:    p.config.DefaultRole,
-				Channel: Channel(match[1]),
-				Content: strings.TrimSpace(match[2]),
-			}
-			
-			if p.config.StrictMode && !p.isValidChannel(msg.Channel) {
-				return nil, fmt.Errorf("invalid channel: %s", msg.Channel)
+	var pattern string
+	if len(messages) > 0 {
+		pattern = "full message pattern"
+	}
+
+	if p.config.JoinSplitCallArgs {
+		messages, raw, spans = joinSplitCallArgs(messages, raw, spans)
+	}
+
+	// Validate commentary call arguments in strict mode once split arguments
+	// are joined. Legacy FUNCTION_CALL arguments are not required to be JSON.
+	if p.config.StrictMode {
+		for i, msg := range messages {
+			if err := checkCallArgs(msg, spans[i][0]); err != nil {
+				return parsed{}, err
 			}
-			
-			messages = append(messages, msg)
 		}
 	}
 
-	// If still no messages found, check for FUNCTION_CALL format
+	// If no Harmony messages found, check for FUNCTION_CALL format
 	if len(messages) == 0 && strings.Contains(content, "FUNCTION_CALL:") {
-		if match := p.functionPattern.FindStringSubmatch(content); match != nil {
+		for _, loc := range p.functionPattern.FindAllStringSubmatchIndex(content, limit) {
+			name := namedGroup(p.functionPattern, content, loc, "name")
+			args := namedGroup(p.functionPattern, content, loc, "args")
+			if p.config.KVCallArgs && !json.Valid([]byte(args)) {
+				if kv, err := p.ParseKVArgs(args); err == nil {
+					if encoded, err := json.Marshal(kv); err == nil {
+						args = string(encoded)
+					}
+				}
+			}
 			msg := Message{
-				Role:    p.config.DefaultRole,
-				Channel: ChannelCommentary,
-				Content: match[2],
-				To:      fmt.Sprintf("functions.%s", match[1]),
-				IsCall:  true,
+				Role:        p.config.DefaultRole,
+				Channel:     ChannelCommentary,
+				Content:     args,
+				To:          p.normalizeRecipient(fmt.Sprintf("functions.%s", name)),
+				IsCall:      true,
+				ArgsAreJSON: json.Valid([]byte(args)),
 			}
 			messages = append(messages, msg)
+			raw = append(raw, namedGroup(p.functionPattern, content, loc, "args"))
+			spans = append(spans, [2]int{loc[0], loc[1]})
+			pattern = "FUNCTION_CALL fallback"
 		}
 	}
 
@@ -167,9 +590,72 @@ func (p *Parser) ParseResponse(content string) ([]Message, error) {
 			Channel: ChannelFinal,
 			Content: content,
 		})
+		raw = append(raw, content)
+		spans = append(spans, [2]int{0, len(content)})
+		pattern = "plain text fallback"
 	}
 
-	return messages, nil
+	// Validate content lengths in strict mode
+	if p.config.StrictMode {
+		for i, msg := range messages {
+			if maxLen, ok := p.config.MaxChannelContent[msg.Channel]; ok && len(msg.Content) > maxLen {
+				detail := fmt.Sprintf("%s has %d bytes, limit %d", msg.Channel, len(msg.Content), maxLen)
+				return parsed{}, &ParseError{Kind: ParseErrorContentTooLong, Offset: spans[i][0], Detail: detail}
+			}
+		}
+	}
+
+	// Apply the recipient policy: fatal in strict mode, recorded as an issue otherwise
+	var issues []ParseError
+	if p.config.RecipientValidator != nil {
+		for i, msg := range messages {
+			if msg.To == "" {
+				continue
+			}
+			if err := p.config.RecipientValidator(msg.To); err != nil {
+				issue := ParseError{Kind: ParseErrorInvalidRecipient, Offset: spans[i][0], Detail: msg.To, Err: err}
+				if p.config.StrictMode {
+					return parsed{}, &issue
+				}
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	for i := range messages {
+		if messages[i].IsCall {
+			messages[i].Complete = messages[i].ArgsAreJSON || !p.config.RequireCompleteCallArgs
+		}
+	}
+
+	if p.config.RecordOffsets || p.config.RecordRuneOffsets {
+		p.recordOffsets(messages, spans, original[:offset], content)
+	}
+
+	if p.config.StripRolePrefix {
+		for i := range messages {
+			if messages[i].Channel == ChannelFinal && !messages[i].IsCall {
+				messages[i].Content = stripRolePrefix(messages[i].Role, messages[i].Content)
+			}
+		}
+	}
+
+	if p.config.ContentTransform != nil {
+		for i := range messages {
+			messages[i].Content = p.config.ContentTransform(messages[i].Channel, messages[i].Content)
+		}
+	}
+
+	return parsed{messages, spans, raw, content, issues, pattern}, nil
+}
+
+// stripRolePrefix removes a leading "role:" from content, ignoring case
+func stripRolePrefix(role, content string) string {
+	prefix := role + ":"
+	if role == "" || len(content) < len(prefix) || !strings.EqualFold(content[:len(prefix)], prefix) {
+		return content
+	}
+	return strings.TrimLeft(content[len(prefix):], " \t")
 }
 
 // ExtractFinalMessage extracts only the user-facing final message from a Harmony response
@@ -187,8 +673,24 @@ func (p *Parser) ExtractFinalMessage(content string) string {
 	for _, msg := range messages {
 		if msg.Channel == ChannelFinal && !msg.IsCall {
 			// Skip function call syntax
-			if !strings.HasPrefix(msg.Content, "FUNCTION_CALL:") {
-				return msg.Content
+			if strings.HasPrefix(msg.Content, "FUNCTION_CALL:") {
+				continue
+			}
+			if p.config.SuppressJSONFinal && isJSONBlob(msg.Content) {
+				continue
+			}
+			return msg.Content
+		}
+	}
+
+	// Optionally stand in for a missing final when the model only reasoned
+	if p.config.SynthesizeFinalFromAnalysis {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Channel == ChannelAnalysis {
+				if p.config.SynthesizedFinal != "" {
+					return p.config.SynthesizedFinal
+				}
+				return messages[i].Content
 			}
 		}
 	}
@@ -197,6 +699,26 @@ func (p *Parser) ExtractFinalMessage(content string) string {
 	return ""
 }
 
+// AnswerWithReasoning returns the first final answer and the analysis messages
+// joined with newlines. Either is empty when the response has none.
+func (p *Parser) AnswerWithReasoning(content string) (answer string, reasoning string) {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return "", ""
+	}
+
+	var analysis []string
+	for _, msg := range messages {
+		switch {
+		case msg.Channel == ChannelAnalysis:
+			analysis = append(analysis, msg.Content)
+		case msg.Channel == ChannelFinal && !msg.IsCall && answer == "" && !strings.HasPrefix(msg.Content, "FUNCTION_CALL:"):
+			answer = msg.Content
+		}
+	}
+	return answer, strings.Join(analysis, "\n")
+}
+
 // ExtractFunctionCall extracts function call information from a Harmony response
 func (p *Parser) ExtractFunctionCall(content string) (functionName string, args string, found bool) {
 	messages, err := p.ParseResponse(content)
@@ -206,7 +728,7 @@ func (p *Parser) ExtractFunctionCall(content string) (functionName string, args
 
 	// Look for function calls in commentary channel
 	for _, msg := range messages {
-		if msg.IsCall && msg.To != "" {
+		if msg.IsCall && msg.Complete && msg.To != "" {
 			// Extract function name from "functions.name" format
 			parts := strings.Split(msg.To, ".")
 			if len(parts) >= 2 {
@@ -216,8 +738,8 @@ func (p *Parser) ExtractFunctionCall(content string) (functionName string, args
 	}
 
 	// Also check for FUNCTION_CALL format
-	if match := p.functionPattern.FindStringSubmatch(content); match != nil {
-		return match[1], match[2], true
+	if loc := p.functionPattern.FindStringSubmatchIndex(content); loc != nil {
+		return namedGroup(p.functionPattern, content, loc, "name"), namedGroup(p.functionPattern, content, loc, "args"), true
 	}
 
 	return "", "", false
@@ -225,6 +747,10 @@ func (p *Parser) ExtractFunctionCall(content string) (functionName string, args
 
 // GetChannelContent extracts content from a specific channel
 func (p *Parser) GetChannelContent(content string, channel Channel) []string {
+	if results, ok := p.scanChannelContent(content, channel); ok {
+		return results
+	}
+
 	messages, err := p.ParseResponse(content)
 	if err != nil {
 		return nil
@@ -239,11 +765,248 @@ func (p *Parser) GetChannelContent(content string, channel Channel) []string {
 	return results
 }
 
+// scanChannelContent is the fast path of GetChannelContent. It scans full
+// format messages without building them and reports false when the full parse
+// is needed: for options that reject or rewrite messages, and for input that
+// only matches a fallback format.
+func (p *Parser) scanChannelContent(content string, channel Channel) ([]string, bool) {
+	c := p.config
+	if c.StrictMode || c.JoinSplitCallArgs || c.StripRolePrefix ||
+		c.TimestampPattern != nil || c.ContentTransform != nil || c.ParseTimeout > 0 {
+		return nil, false
+	}
+
+	content = sanitizeInput(content)
+	if c.StripCodeFences {
+		content, _ = stripCodeFences(content)
+	}
+
+	var results []string
+	found := false
+	for _, loc := range p.messagePattern.FindAllStringSubmatchIndex(content, -1) {
+		keyword := namedGroup(p.messagePattern, content, loc, "channel")
+		found = true
+
+		msgChannel := c.DefaultChannel
+		if keyword != "" {
+			msgChannel = p.resolveChannel(keyword)
+		}
+		if msgChannel == channel {
+			results = append(results, strings.TrimSpace(namedGroup(p.messagePattern, content, loc, "content")))
+		}
+	}
+	return results, found
+}
+
+// ChannelMap groups message contents by channel, joined with newlines
+func (p *Parser) ChannelMap(content string) map[Channel]string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	parts := make(map[Channel][]string)
+	for _, msg := range messages {
+		parts[msg.Channel] = append(parts[msg.Channel], msg.Content)
+	}
+
+	result := make(map[Channel]string, len(parts))
+	for channel, contents := range parts {
+		result[channel] = strings.Join(contents, "\n")
+	}
+	return result
+}
+
+// ContentBetween returns the raw text between the end of the first fromChannel
+// message and the start of the next toChannel message, or "" if either is missing
+func (p *Parser) ContentBetween(content string, fromChannel, toChannel Channel) string {
+	r, err := p.parseWithTimeout(content, -1)
+	if err != nil {
+		return ""
+	}
+
+	from := -1
+	for i, msg := range r.messages {
+		if from == -1 {
+			if msg.Channel == fromChannel {
+				from = i
+			}
+			continue
+		}
+		if msg.Channel == toChannel {
+			return r.text[r.spans[from][1]:r.spans[i][0]]
+		}
+	}
+	return ""
+}
+
+// GetChannelMessages extracts the full messages from a specific channel
+func (p *Parser) GetChannelMessages(content string, channel Channel) []Message {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	var results []Message
+	for _, msg := range messages {
+		if msg.Channel == channel {
+			results = append(results, msg)
+		}
+	}
+	return results
+}
+
+// ExtractReasoningSummary returns a short summary of the model's reasoning: the
+// non-empty analysis message selected by the configured SummaryStrategy
+func (p *Parser) ExtractReasoningSummary(content string) string {
+	summary := ""
+	for _, text := range p.GetChannelContent(content, ChannelAnalysis) {
+		if text == "" {
+			continue
+		}
+		if summary == "" || p.config.SummaryStrategy != SummaryShortest ||
+			utf8.RuneCountInString(text) < utf8.RuneCountInString(summary) {
+			summary = text
+		}
+	}
+	return summary
+}
+
+// ExtractCommentaryText returns the explanatory commentary contents, excluding
+// tool calls and tool outputs sent back on the commentary channel
+func (p *Parser) ExtractCommentaryText(content string) []string {
+	var texts []string
+	for _, msg := range p.GetChannelMessages(content, ChannelCommentary) {
+		if msg.IsCall {
+			continue
+		}
+		if isToolRole(msg.Role) {
+			continue
+		}
+		texts = append(texts, msg.Content)
+	}
+	return texts
+}
+
+// ExtractStatusLine returns the first commentary text that comes before the
+// final answer, such as a status line shown while the answer is generated.
+// Commentary after the final message is not a status line.
+func (p *Parser) ExtractStatusLine(content string) string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return ""
+	}
+
+	for _, msg := range messages {
+		if msg.Channel == ChannelFinal {
+			return ""
+		}
+		if msg.Channel == ChannelCommentary && !msg.IsCall && !isToolRole(msg.Role) {
+			return msg.Content
+		}
+	}
+	return ""
+}
+
 // GetAllMessages returns all parsed messages with their channels
 func (p *Parser) GetAllMessages(content string) ([]Message, error) {
 	return p.ParseResponse(content)
 }
 
+// ParseResponseReversed parses a response and returns messages most-recent-first
+func (p *Parser) ParseResponseReversed(content string) ([]Message, error) {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// DetectFormat reports which format variant a response uses
+func (p *Parser) DetectFormat(content string) Format {
+	matches := p.messagePattern.FindAllString(content, -1)
+	for _, match := range matches {
+		if strings.HasPrefix(match, "<|start|>") {
+			return FormatHarmonyFull
+		}
+	}
+	for _, match := range matches {
+		if strings.Contains(match, "<|channel|>") {
+			return FormatHarmonySimple
+		}
+	}
+	if p.functionPattern.MatchString(content) {
+		return FormatFunctionCall
+	}
+	return FormatPlain
+}
+
+// IsPlainText reports whether content has no Harmony special tokens or
+// FUNCTION_CALL: markers, so callers can skip parsing entirely
+func (p *Parser) IsPlainText(content string) bool {
+	return !strings.Contains(content, "FUNCTION_CALL:") && !specialTokenPattern.MatchString(content)
+}
+
+// ResponseKind classifies a response by the action it asks for.
+// Tool calls take precedence over final answers.
+func (p *Parser) ResponseKind(content string) ResponseKind {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return KindEmpty
+	}
+
+	kind := KindEmpty
+	for _, msg := range messages {
+		switch {
+		case msg.IsCall:
+			return KindToolCall
+		case msg.Channel == ChannelFinal && strings.TrimSpace(msg.Content) != "":
+			kind = KindFinalAnswer
+		case (msg.Channel == ChannelAnalysis || msg.Channel == ChannelCommentary) && kind == KindEmpty:
+			kind = KindReasoningOnly
+		}
+	}
+	return kind
+}
+
+// Split separates a response into what the user sees (final channel) and the
+// hidden analysis and commentary, each joined with newlines
+func (p *Parser) Split(content string) (visible string, hidden string) {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return "", ""
+	}
+
+	var shown, rest []string
+	for _, msg := range messages {
+		switch msg.Channel {
+		case ChannelFinal:
+			shown = append(shown, msg.Content)
+		case ChannelAnalysis, ChannelCommentary:
+			rest = append(rest, msg.Content)
+		}
+	}
+	return strings.Join(shown, "\n"), strings.Join(rest, "\n")
+}
+
+// AnalysisRecipients returns the distinct recipients of analysis messages in
+// order of first appearance, for multi-agent flows that address sub-agents
+func (p *Parser) AnalysisRecipients(content string) []string {
+	var recipients []string
+	seen := make(map[string]bool)
+	for _, msg := range p.GetChannelMessages(content, ChannelAnalysis) {
+		if msg.To != "" && !seen[msg.To] {
+			seen[msg.To] = true
+			recipients = append(recipients, msg.To)
+		}
+	}
+	return recipients
+}
+
 // HasChannel checks if a response contains a specific channel
 func (p *Parser) HasChannel(content string, channel Channel) bool {
 	messages, err := p.ParseResponse(content)
@@ -259,6 +1022,121 @@ func (p *Parser) HasChannel(content string, channel Channel) bool {
 	return false
 }
 
+// ChannelsPresent returns the distinct channels in a response, in order of
+// first appearance
+func (p *Parser) ChannelsPresent(content string) []Channel {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	var channels []Channel
+	seen := make(map[Channel]bool)
+	for _, msg := range messages {
+		if !seen[msg.Channel] {
+			seen[msg.Channel] = true
+			channels = append(channels, msg.Channel)
+		}
+	}
+	return channels
+}
+
+// IsEmpty reports whether a response has no messages with non-whitespace content.
+// Responses that fail to parse are also considered empty.
+func (p *Parser) IsEmpty(content string) bool {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return true
+	}
+
+	for _, msg := range messages {
+		if strings.TrimSpace(msg.Content) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// VisibleLength returns the number of characters the user sees in the final channel
+func (p *Parser) VisibleLength(content string) int {
+	count := 0
+	for _, text := range p.GetChannelContent(content, ChannelFinal) {
+		count += utf8.RuneCountInString(text)
+	}
+	return count
+}
+
+// Summarize returns a one-line summary of a response such as
+// "2 analysis, 1 call(get_weather), 1 final", in order of first appearance.
+// Messages without a channel are counted under their role (e.g., "1 user").
+func (p *Parser) Summarize(content string) string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return ""
+	}
+
+	var order []string
+	counts := make(map[string]int)
+	var calls []string
+	for _, msg := range messages {
+		key := string(msg.Channel)
+		if msg.Channel == ChannelNone {
+			key = msg.Role
+		}
+		if msg.IsCall {
+			key = "call"
+			_, name := splitRecipient(msg.To)
+			calls = append(calls, name)
+		}
+		if counts[key] == 0 {
+			order = append(order, key)
+		}
+		counts[key]++
+	}
+
+	parts := make([]string, len(order))
+	for i, key := range order {
+		if key == "call" {
+			parts[i] = fmt.Sprintf("%d call(%s)", counts[key], strings.Join(calls, ", "))
+		} else {
+			parts[i] = fmt.Sprintf("%d %s", counts[key], key)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FlattenLabeled returns all messages as one string, one String() line per
+// message prefixed with [role/channel], for grep-friendly logs
+func (p *Parser) FlattenLabeled(content string) string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return ""
+	}
+
+	lines := make([]string, len(messages))
+	for i, msg := range messages {
+		lines[i] = msg.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ExtractCitations extracts citation markers from final-channel content
+func (p *Parser) ExtractCitations(content string) []string {
+	pattern := p.config.CitationPattern
+	if pattern == nil {
+		pattern = defaultCitationPattern
+	}
+
+	var citations []string
+	for _, msg := range p.GetChannelMessages(content, ChannelFinal) {
+		if msg.IsCall {
+			continue
+		}
+		citations = append(citations, pattern.FindAllString(msg.Content, -1)...)
+	}
+	return citations
+}
+
 // ExtractJSON attempts to extract and parse JSON from message content
 func (p *Parser) ExtractJSON(content string) (map[string]interface{}, error) {
 	// Try to find JSON in the content
@@ -270,6 +1148,11 @@ func (p *Parser) ExtractJSON(content string) (map[string]interface{}, error) {
 	}
 	
 	jsonStr := content[jsonStart : jsonEnd+1]
+	if p.config.MaxJSONDepth > 0 {
+		if err := checkJSONDepth(jsonStr, p.config.MaxJSONDepth); err != nil {
+			return nil, err
+		}
+	}
 	
 	var result map[string]interface{}
 	if err := json.Unmarshal([]byte(jsonStr), &result); err != nil {
@@ -279,6 +1162,317 @@ func (p *Parser) ExtractJSON(content string) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// checkJSONDepth reports ErrJSONTooDeep if objects and arrays in data nest
+// deeper than maxDepth. It reads tokens iteratively, so deep input cannot
+// exhaust the stack. Malformed JSON is left for the caller's decoder to report.
+func checkJSONDepth(data string, maxDepth int) error {
+	dec := json.NewDecoder(strings.NewReader(data))
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				return fmt.Errorf("%w: limit %d", ErrJSONTooDeep, maxDepth)
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// ExtractTrailingMetadata parses a JSON object appended after the last message
+// terminator, such as a {"confidence": 0.9} footer
+func (p *Parser) ExtractTrailingMetadata(content string) (map[string]interface{}, bool) {
+	end := lastTerminatorEnd(content)
+	if end == -1 {
+		return nil, false
+	}
+
+	var metadata map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content[end:])), &metadata); err != nil || metadata == nil {
+		return nil, false
+	}
+	return metadata, true
+}
+
+// joinSplitCallArgs merges call arguments split across several commentary
+// messages. A run starts at a commentary message addressed to a tool that did
+// not end with <|call|>, and continues through commentary messages with no or the same
+// recipient until one ends with <|call|>. Raw holds the untrimmed contents and
+// spans the input offsets of each message; the joined raw contents and spans
+// are returned.
+func joinSplitCallArgs(messages []Message, raw []string, spans [][2]int) ([]Message, []string, [][2]int) {
+	var joined []Message
+	var joinedRaw []string
+	var joinedSpans [][2]int
+	for i := 0; i < len(messages); i++ {
+		msg := messages[i]
+		namespace, _ := splitRecipient(msg.To)
+		if msg.Channel != ChannelCommentary || msg.IsCall || namespace == "" {
+			joined = append(joined, msg)
+			joinedRaw = append(joinedRaw, raw[i])
+			joinedSpans = append(joinedSpans, spans[i])
+			continue
+		}
+
+		args := raw[i]
+		end := -1
+		for j := i + 1; j < len(messages); j++ {
+			next := messages[j]
+			if next.Channel != ChannelCommentary || (next.To != "" && next.To != msg.To) {
+				break
+			}
+			args += raw[j]
+			if next.IsCall {
+				end = j
+				break
+			}
+		}
+		if end == -1 {
+			joined = append(joined, msg)
+			joinedRaw = append(joinedRaw, raw[i])
+			joinedSpans = append(joinedSpans, spans[i])
+			continue
+		}
+
+		msg.Content = strings.TrimSpace(args)
+		msg.IsCall = true
+		msg.ArgsAreJSON = json.Valid([]byte(msg.Content))
+		joined = append(joined, msg)
+		joinedRaw = append(joinedRaw, args)
+		joinedSpans = append(joinedSpans, [2]int{spans[i][0], spans[end][1]})
+		i = end
+	}
+	return joined, joinedRaw, joinedSpans
+}
+
+// checkCallArgs rejects a commentary call whose arguments are not valid JSON,
+// unless the call declares a non-JSON content type via <|constrain|>
+func checkCallArgs(msg Message, offset int) error {
+	if !msg.IsCall || msg.Channel != ChannelCommentary || msg.ArgsAreJSON {
+		return nil
+	}
+	if msg.Constrain != "" && !strings.EqualFold(msg.Constrain, "json") {
+		return nil
+	}
+	_, name := splitRecipient(msg.To)
+	return &ParseError{Kind: ParseErrorInvalidArgs, Offset: offset, Detail: name}
+}
+
+// resolveChannel maps a channel keyword through the configured aliases
+func (p *Parser) resolveChannel(keyword string) Channel {
+	if channel, ok := p.config.ChannelAliases[keyword]; ok {
+		return channel
+	}
+	return Channel(keyword)
+}
+
+// normalizeRecipient canonicalizes the casing of a recipient if configured
+func (p *Parser) normalizeRecipient(to string) string {
+	if !p.config.NormalizeRecipients || to == "" {
+		return to
+	}
+
+	namespace, name := splitRecipient(to)
+	if namespace == "" {
+		return strings.ToLower(to)
+	}
+	if p.config.LowercaseFunctionNames {
+		name = strings.ToLower(name)
+	}
+	return strings.ToLower(namespace) + "." + name
+}
+
+// defaultCitationPattern matches 【...】 and [n] citation markers
+var defaultCitationPattern = regexp.MustCompile(`【[^】]*】|\[\d+\]`)
+
+// sanitizeInput strips a leading UTF-8 byte order mark and non-printing control
+// characters. Leading tabs and line breaks are only stripped before a special
+// token, so plain text content is left unchanged.
+func sanitizeInput(content string) string {
+	content = strings.TrimLeftFunc(content, func(r rune) bool {
+		return r == '\uFEFF' || (unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r')
+	})
+	if trimmed := strings.TrimLeft(content, "\t\r\n"); strings.HasPrefix(trimmed, "<|") {
+		return trimmed
+	}
+	return content
+}
+
+// codeFencePattern matches input wrapped in a fenced code block
+var codeFencePattern = regexp.MustCompile("(?s)^\\s*```[\\w-]*[ \\t]*\\r?\\n(.*?)\\r?\\n?```\\s*$")
+
+// stripCodeFences removes a code fence surrounding the whole input and returns
+// the offset of the remaining text in the input
+func stripCodeFences(content string) (string, int) {
+	if loc := codeFencePattern.FindStringSubmatchIndex(content); loc != nil {
+		return content[loc[2]:loc[3]], loc[2]
+	}
+	return content, 0
+}
+
+// recordOffsets stores each message's span on the message. Offsets are shifted
+// past the prefix removed by sanitizeInput and stripCodeFences so they refer to
+// the caller's input.
+func (p *Parser) recordOffsets(messages []Message, spans [][2]int, prefix, text string) {
+	prefixRunes := 0
+	if p.config.RecordRuneOffsets {
+		prefixRunes = utf8.RuneCountInString(prefix)
+	}
+
+	// Spans are nearly always in input order, so count runes incrementally
+	lastByte, lastRune := 0, 0
+	for i := range messages {
+		start, end := spans[i][0], spans[i][1]
+		messages[i].Start = start + len(prefix)
+		messages[i].End = end + len(prefix)
+		if !p.config.RecordRuneOffsets {
+			continue
+		}
+
+		if start < lastByte {
+			lastByte, lastRune = 0, 0
+		}
+		startRune := lastRune + utf8.RuneCountInString(text[lastByte:start])
+		endRune := startRune + utf8.RuneCountInString(text[start:end])
+		messages[i].StartRune = startRune + prefixRunes
+		messages[i].EndRune = endRune + prefixRunes
+		lastByte, lastRune = start, startRune
+	}
+}
+
+// messageMatch holds the named groups of a messagePattern match. Groups are
+// empty when absent from the match or from the pattern.
+type messageMatch struct {
+	full       string
+	role       string
+	attrs      string // name= and tool_call_id= attributes after the role
+	roleTo     string // recipient in the role header
+	channel    string
+	to         string // recipient after the channel
+	constrain  string
+	content    string
+	terminator string // empty if unterminated
+}
+
+// matchMessage extracts the named groups of a messagePattern match
+func (p *Parser) matchMessage(content string, loc []int) messageMatch {
+	re := p.messagePattern
+	return messageMatch{
+		full:       content[loc[0]:loc[1]],
+		role:       namedGroup(re, content, loc, "role"),
+		attrs:      namedGroup(re, content, loc, "attrs"),
+		roleTo:     namedGroup(re, content, loc, "role_to"),
+		channel:    namedGroup(re, content, loc, "channel"),
+		to:         namedGroup(re, content, loc, "to"),
+		constrain:  namedGroup(re, content, loc, "constrain"),
+		content:    namedGroup(re, content, loc, "content"),
+		terminator: namedGroup(re, content, loc, "terminator"),
+	}
+}
+
+// toolAttrPattern matches a name= or tool_call_id= attribute in a role header
+var toolAttrPattern = regexp.MustCompile(`(name|tool_call_id)=([\w.:-]+)`)
+
+// parseToolAttrs extracts the tool name and call ID from role header attributes
+func parseToolAttrs(attrs string) (name, callID string) {
+	for _, match := range toolAttrPattern.FindAllStringSubmatch(attrs, -1) {
+		if match[1] == "name" {
+			name = match[2]
+		} else {
+			callID = match[2]
+		}
+	}
+	return name, callID
+}
+
+// namedGroup returns the text of the named group in a match of re, or "" if
+// re has no such group or it did not participate, so patterns with a
+// different group layout cannot cause out-of-range indexing. A name declared
+// in several alternatives yields the group that participated.
+func namedGroup(re *regexp.Regexp, content string, loc []int, name string) string {
+	for i, group := range re.SubexpNames() {
+		if group == name && 2*i+1 < len(loc) && loc[2*i] >= 0 {
+			return content[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return ""
+}
+
+// submatches converts submatch indices into strings, using "" for unmatched groups
+func submatches(content string, loc []int) []string {
+	match := make([]string, len(loc)/2)
+	for i := range match {
+		if loc[2*i] >= 0 {
+			match[i] = content[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return match
+}
+
+// trailingTimestamp finds a timestamp at the end of the text preceding a message
+func (p *Parser) trailingTimestamp(gap string) string {
+	gap = strings.TrimRight(gap, " \t\r\n")
+	locs := p.config.TimestampPattern.FindAllStringSubmatchIndex(gap, -1)
+	if len(locs) == 0 || locs[len(locs)-1][1] != len(gap) {
+		return ""
+	}
+	return timestampValue(submatches(gap, locs[len(locs)-1]))
+}
+
+// leadingTimestamp strips a timestamp from the start of message content
+func (p *Parser) leadingTimestamp(content string) (timestamp, rest string) {
+	loc := p.config.TimestampPattern.FindStringSubmatchIndex(content)
+	if loc == nil || loc[0] != 0 {
+		return "", content
+	}
+	return timestampValue(submatches(content, loc)), strings.TrimSpace(content[loc[1]:])
+}
+
+// timestampValue picks the first capture group if present, else the whole match
+func timestampValue(match []string) string {
+	if len(match) > 1 && match[1] != "" {
+		return match[1]
+	}
+	return match[0]
+}
+
+// ExtractChannelJSON extracts JSON only from messages in the given channel.
+// The first message containing valid JSON is used.
+func (p *Parser) ExtractChannelJSON(content string, channel Channel) (map[string]interface{}, error) {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := fmt.Errorf("no JSON found in %s channel", channel)
+	for _, msg := range messages {
+		if msg.Channel != channel {
+			continue
+		}
+		result, err := p.ExtractJSON(msg.Content)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// isJSONBlob checks if content is entirely a JSON object or array
+func isJSONBlob(content string) bool {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return false
+	}
+	return json.Valid([]byte(trimmed))
+}
+
 // isValidChannel checks if a channel is valid
 func (p *Parser) isValidChannel(channel Channel) bool {
 	switch channel {
@@ -289,10 +1483,89 @@ func (p *Parser) isValidChannel(channel Channel) bool {
 	}
 }
 
-// String returns a string representation of a Message
-func (m Message) String() string {
+// WithChannel returns a copy of the message moved to another channel
+func (m Message) WithChannel(c Channel) Message {
+	m.Channel = c
+	return m
+}
+
+// WithContent returns a copy of the message with replaced content
+func (m Message) WithContent(content string) Message {
+	m.Content = content
+	return m
+}
+
+// WithDefaultRole returns a copy of the message with Role set if it is empty
+func (m Message) WithDefaultRole(role string) Message {
+	if m.Role == "" {
+		m.Role = role
+	}
+	return m
+}
+
+// Validate checks a message for internal consistency before it is encoded.
+// Messages with empty content are rejected; use ValidateAllowEmpty to accept them.
+func (m Message) Validate() error {
+	if m.Content == "" {
+		return fmt.Errorf("message has empty content")
+	}
+	return m.ValidateAllowEmpty()
+}
+
+// ValidateAllowEmpty checks a message like Validate but accepts empty content
+func (m Message) ValidateAllowEmpty() error {
+	switch m.Channel {
+	case ChannelNone, ChannelAnalysis, ChannelCommentary, ChannelFinal:
+	default:
+		return fmt.Errorf("invalid channel: %s", m.Channel)
+	}
+	if m.To != "" && !m.IsCall && m.Channel != ChannelCommentary {
+		return fmt.Errorf("recipient %s set on non-call message in channel %q", m.To, m.Channel)
+	}
+	if m.IsCall && m.To == "" {
+		return fmt.Errorf("call message has no recipient")
+	}
+	if m.IsCall && m.IsReturn {
+		return fmt.Errorf("message cannot be both a call and a return")
+	}
+	return nil
+}
+
+// FormatStyle selects how Message.Format renders a message
+type FormatStyle string
+
+const (
+	// StyleCompact is the short "[role/channel] content" form used by String
+	StyleCompact FormatStyle = "compact"
+	// StyleVerbose lists routing fields and flags as key=value pairs
+	StyleVerbose FormatStyle = "verbose"
+	// StyleJSONLine renders the message as a single line of JSON
+	StyleJSONLine FormatStyle = "json_line"
+)
+
+// Format returns a string representation of a Message in the given style
+func (m Message) Format(style FormatStyle) string {
+	switch style {
+	case StyleVerbose:
+		return fmt.Sprintf("role=%s channel=%s to=%s constrain=%s tool_name=%s tool_call_id=%s "+
+			"is_call=%t args_are_json=%t complete=%t is_return=%t timestamp=%s content=%q",
+			m.Role, m.Channel, m.To, m.Constrain, m.ToolName, m.CallID,
+			m.IsCall, m.ArgsAreJSON, m.Complete, m.IsReturn, m.Timestamp, m.Content)
+	case StyleJSONLine:
+		data, err := json.Marshal(m)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
 	if m.IsCall {
 		return fmt.Sprintf("[%s/%s] Function call to %s: %s", m.Role, m.Channel, m.To, m.Content)
 	}
 	return fmt.Sprintf("[%s/%s] %s", m.Role, m.Channel, m.Content)
-}
\ No newline at end of file
+}
+
+// String returns a string representation of a Message
+func (m Message) String() string {
+	return m.Format(StyleCompact)
+}
@@ -1,8 +1,12 @@
 package goharmony
 
 import (
+	"errors"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewParser(t *testing.T) {
@@ -15,6 +19,49 @@ func TestNewParser(t *testing.T) {
 	}
 }
 
+func TestParserClone(t *testing.T) {
+	base := NewParser()
+	strict := base.Clone(func(c *ParserConfig) { c.StrictMode = true })
+
+	if base.config.StrictMode {
+		t.Error("Clone() modified the original parser config")
+	}
+	if !strict.config.StrictMode {
+		t.Error("Clone() did not apply the modifier")
+	}
+	if strict.messagePattern != base.messagePattern {
+		t.Error("Clone() rebuilt a pattern that does not depend on StrictMode")
+	}
+
+	input := `<|channel|>invalid<|message|>Test<|end|>`
+	if _, err := base.ParseResponse(input); err != nil {
+		t.Errorf("base ParseResponse() unexpected error: %v", err)
+	}
+	if _, err := strict.ParseResponse(input); err == nil {
+		t.Error("strict ParseResponse() expected error for invalid channel")
+	}
+
+	tolerant := base.Clone(func(c *ParserConfig) { c.Tolerant = true })
+	if tolerant.messagePattern == base.messagePattern {
+		t.Error("Clone() did not rebuild the message pattern for Tolerant")
+	}
+
+	config := DefaultConfig()
+	config.ChannelAliases = map[string]Channel{"final_v2": ChannelFinal}
+	config.MaxChannelContent = map[Channel]int{ChannelAnalysis: 100}
+	withMaps := NewParserWithConfig(config)
+	withMaps.Clone(func(c *ParserConfig) {
+		c.ChannelAliases["x"] = ChannelAnalysis
+		c.MaxChannelContent[ChannelFinal] = 10
+	})
+	if !reflect.DeepEqual(withMaps.config.ChannelAliases, map[string]Channel{"final_v2": ChannelFinal}) {
+		t.Errorf("Clone() modified the original ChannelAliases: %v", withMaps.config.ChannelAliases)
+	}
+	if !reflect.DeepEqual(withMaps.config.MaxChannelContent, map[Channel]int{ChannelAnalysis: 100}) {
+		t.Errorf("Clone() modified the original MaxChannelContent: %v", withMaps.config.MaxChannelContent)
+	}
+}
+
 func TestParseResponse_BasicChannels(t *testing.T) {
 	parser := NewParser()
 	
@@ -68,6 +115,342 @@ func TestParseResponse_BasicChannels(t *testing.T) {
 	}
 }
 
+func TestParseResponse_RoleWithoutStartTag(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name         string
+		input        string
+		expectedRole string
+	}{
+		{
+			name:         "Bare standard role",
+			input:        `user<|channel|>final<|message|>hi<|end|>`,
+			expectedRole: "user",
+		},
+		{
+			name: "Bare role after previous message",
+			input: `<|channel|>analysis<|message|>Thinking<|end|>
+system<|channel|>final<|message|>hi<|end|>`,
+			expectedRole: "system",
+		},
+		{
+			name:         "Unknown word is not a role",
+			input:        `Hello world<|channel|>final<|message|>hi<|end|>`,
+			expectedRole: "assistant",
+		},
+		{
+			name:         "Any role after start tag",
+			input:        `<|start|>planner<|channel|>final<|message|>hi<|end|>`,
+			expectedRole: "planner",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := parser.ParseResponse(tt.input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			last := messages[len(messages)-1]
+			if last.Role != tt.expectedRole || last.Content != "hi" {
+				t.Errorf("ParseResponse() = %v, want role %v with content hi", last, tt.expectedRole)
+			}
+		})
+	}
+}
+
+func TestParseResponse_Timestamps(t *testing.T) {
+	config := DefaultConfig()
+	config.TimestampPattern = regexp.MustCompile(`\[(\d{4}-\d{2}-\d{2}T[\d:]+Z)\]`)
+	parser := NewParserWithConfig(config)
+
+	input := `[2024-01-01T00:00:00Z] <|channel|>analysis<|message|>Thinking<|end|>
+[2024-01-01T00:00:05Z] <|channel|>final<|message|>Hello<|end|>
+<|channel|>final<|message|>[2024-01-01T00:00:09Z] Inline<|end|>`
+
+	expected := []Message{
+		{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking", Timestamp: "2024-01-01T00:00:00Z"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Hello", Timestamp: "2024-01-01T00:00:05Z"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Inline", Timestamp: "2024-01-01T00:00:09Z"},
+	}
+
+	messages, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+	}
+}
+
+func TestParseResponse_QuotedKeywords(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected Message
+	}{
+		{
+			name:     "Unquoted channel",
+			input:    `<|channel|>final<|message|>Hello<|end|>`,
+			expected: Message{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+		},
+		{
+			name:     "Double-quoted channel",
+			input:    `<|channel|>"final"<|message|>Hello<|end|>`,
+			expected: Message{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+		},
+		{
+			name:     "Single-quoted channel",
+			input:    `<|channel|>'analysis'<|message|>Hello<|end|>`,
+			expected: Message{Role: "assistant", Channel: ChannelAnalysis, Content: "Hello"},
+		},
+		{
+			name:     "Quoted role and channel",
+			input:    `<|start|>"system"<|channel|>"final"<|message|>Hello<|end|>`,
+			expected: Message{Role: "system", Channel: ChannelFinal, Content: "Hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := parser.ParseResponse(tt.input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if len(messages) != 1 || !reflect.DeepEqual(messages[0], tt.expected) {
+				t.Errorf("ParseResponse() = %v, want %v", messages, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseResponse_DefaultChannel(t *testing.T) {
+	input := `<|start|>user<|message|>What's the weather?<|end|>
+<|start|>assistant<|channel|>final<|message|>Sunny<|end|>`
+
+	messages, err := NewParser().ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	expected := []Message{
+		{Role: "user", Channel: ChannelNone, Content: "What's the weather?"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Sunny"},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+	}
+
+	config := DefaultConfig()
+	config.DefaultChannel = ChannelCommentary
+	messages, err = NewParserWithConfig(config).ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if messages[0].Channel != ChannelCommentary || messages[1].Channel != ChannelFinal {
+		t.Errorf("ParseResponse() = %v, want default channel applied to the first message only", messages)
+	}
+}
+
+func TestParseResponse_StripCodeFences(t *testing.T) {
+	config := DefaultConfig()
+	config.StripCodeFences = true
+	parser := NewParserWithConfig(config)
+
+	unfenced := `<|channel|>analysis<|message|>Thinking<|end|>
+<|channel|>final<|message|>Hello<|end|>`
+	expected, err := parser.ParseResponse(unfenced)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	inputs := []string{
+		"```harmony\n" + unfenced + "\n```",
+		"```\n" + unfenced + "\n```\n",
+		"  ```harmony\r\n" + unfenced + "\r\n```",
+	}
+	for _, input := range inputs {
+		messages, err := parser.ParseResponse(input)
+		if err != nil {
+			t.Fatalf("ParseResponse() error = %v", err)
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Errorf("ParseResponse(%q) = %v, want %v", input, messages, expected)
+		}
+	}
+}
+
+func TestParseResponse_Tolerant(t *testing.T) {
+	input := "<|start|>assistant\n<|channel|>\nfinal\n<|message|>Hello<|end|>"
+
+	config := DefaultConfig()
+	config.Tolerant = true
+	messages, err := NewParserWithConfig(config).ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	expected := []Message{{Role: "assistant", Channel: ChannelFinal, Content: "Hello"}}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+	}
+
+	// Without tolerance the split header is not recognized as a final message
+	messages, err = NewParser().ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() without Tolerant = %v, want no clean final message", messages)
+	}
+}
+
+func TestParseResponse_SimplifiedCRLF(t *testing.T) {
+	tests := []struct {
+		name     string
+		tolerant bool
+		input    string
+		expected []Message
+	}{
+		{
+			name: "Multi-line content",
+			input: "<|channel|>analysis<|message|>Line one\r\nLine two<|end|>\r\n" +
+				"<|channel|>final<|message|>Done\r\n<|end|>",
+			expected: []Message{
+				{Role: "assistant", Channel: ChannelAnalysis, Content: "Line one\r\nLine two"},
+				{Role: "assistant", Channel: ChannelFinal, Content: "Done"},
+			},
+		},
+		{
+			name:     "Line breaks around the channel keyword",
+			tolerant: true,
+			input:    "<|channel|>\r\nfinal \r\n<|message|>Line one\r\nLine two",
+			expected: []Message{
+				{Role: "assistant", Channel: ChannelFinal, Content: "Line one\r\nLine two"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Tolerant = tt.tolerant
+			parser := NewParserWithConfig(config)
+
+			messages, err := parser.ParseResponse(tt.input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("ParseResponse() = %v, want %v", messages, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseResponse_AlternatePatternGroups(t *testing.T) {
+	ts := TokenSet{
+		Start:     "[start]",
+		End:       "[end]",
+		Channel:   "[channel]",
+		Message:   "[message]",
+		Call:      "[call]",
+		Return:    "[return]",
+		Constrain: "[constrain]",
+	}
+	input := EncodeWithTokenSet([]Message{
+		{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+	}, ts)
+
+	tests := []struct {
+		name     string
+		pattern  string
+		expected []Message
+	}{
+		{
+			name: "Fewer named groups",
+			pattern: `(?s)` + regexp.QuoteMeta(ts.Channel) + `(?P<channel>\w+)` + regexp.QuoteMeta(ts.Message) +
+				`(?P<content>.*?)(?P<terminator>` + regexp.QuoteMeta(ts.End) + `|$)`,
+			expected: []Message{
+				{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking"},
+				{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+			},
+		},
+		{
+			name:    "Unnamed groups only",
+			pattern: `(?s)` + regexp.QuoteMeta(ts.Message) + `(.*?)` + regexp.QuoteMeta(ts.End),
+			// Without content or channel groups each match is an empty message
+			expected: []Message{
+				{Role: "assistant", Channel: ChannelNone},
+				{Role: "assistant", Channel: ChannelNone},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewParser()
+			parser.messagePattern = regexp.MustCompile(tt.pattern)
+
+			messages, err := parser.ParseResponse(input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("ParseResponse() = %v, want %v", messages, tt.expected)
+			}
+			if count := parser.CountToolCalls(input + "<|call|>"); count != 0 {
+				t.Errorf("CountToolCalls() = %d, want 0", count)
+			}
+		})
+	}
+}
+
+func TestParseResponse_LeadingBOM(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []Message
+	}{
+		{
+			name:     "BOM before start tag",
+			input:    "\uFEFF<|start|>assistant<|channel|>final<|message|>Hello<|end|>",
+			expected: []Message{{Role: "assistant", Channel: ChannelFinal, Content: "Hello"}},
+		},
+		{
+			name:     "BOM and newlines before a start tag",
+			input:    "\uFEFF\x00\r\n\n<|start|>assistant<|channel|>final<|message|>Hello<|end|>",
+			expected: []Message{{Role: "assistant", Channel: ChannelFinal, Content: "Hello"}},
+		},
+		{
+			name:     "Leading whitespace in plain text is kept",
+			input:    "\uFEFF\x00\tindented code",
+			expected: []Message{{Role: "assistant", Channel: ChannelFinal, Content: "\tindented code"}},
+		},
+		{
+			name:     "Only a BOM",
+			input:    "\uFEFF",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := parser.ParseResponse(tt.input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("ParseResponse() = %v, want %v", messages, tt.expected)
+			}
+		})
+	}
+}
+
 func TestParseResponse_FunctionCalls(t *testing.T) {
 	parser := NewParser()
 	
@@ -80,22 +463,26 @@ func TestParseResponse_FunctionCalls(t *testing.T) {
 			name:  "Harmony format function call",
 			input: `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`,
 			expected: Message{
-				Role:    "assistant",
-				Channel: ChannelCommentary,
-				Content: `{"location": "NYC"}`,
-				To:      "functions.get_weather",
-				IsCall:  true,
+				Role:        "assistant",
+				Channel:     ChannelCommentary,
+				Content:     `{"location": "NYC"}`,
+				To:          "functions.get_weather",
+				IsCall:      true,
+				ArgsAreJSON: true,
+				Complete:    true,
 			},
 		},
 		{
 			name:  "FUNCTION_CALL format",
 			input: `FUNCTION_CALL: get_weather({"location": "NYC"})`,
 			expected: Message{
-				Role:    "assistant",
-				Channel: ChannelCommentary,
-				Content: `{"location": "NYC"}`,
-				To:      "functions.get_weather",
-				IsCall:  true,
+				Role:        "assistant",
+				Channel:     ChannelCommentary,
+				Content:     `{"location": "NYC"}`,
+				To:          "functions.get_weather",
+				IsCall:      true,
+				ArgsAreJSON: true,
+				Complete:    true,
 			},
 		},
 	}
@@ -116,143 +503,1233 @@ func TestParseResponse_FunctionCalls(t *testing.T) {
 	}
 }
 
-func TestExtractFinalMessage(t *testing.T) {
+func TestParseResponse_ArgsAreJSON(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "JSON call content",
+			input:    `<|channel|>commentary to=functions.search<|message|>{"query": "news"}<|call|>`,
+			expected: true,
+		},
+		{
+			name:     "Free text call content",
+			input:    `<|channel|>commentary to=python<|message|>print("hello")<|call|>`,
+			expected: false,
+		},
+		{
+			name:     "Legacy JSON call",
+			input:    `FUNCTION_CALL: search({"query": "news"})`,
+			expected: true,
+		},
+		{
+			name:     "Legacy free text call",
+			input:    `FUNCTION_CALL: search(latest news)`,
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := parser.ParseResponse(tt.input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if len(messages) != 1 || !messages[0].IsCall {
+				t.Fatalf("ParseResponse() = %v, want a single call", messages)
+			}
+			if messages[0].ArgsAreJSON != tt.expected {
+				t.Errorf("ArgsAreJSON = %v, want %v", messages[0].ArgsAreJSON, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseResponse_ConstrainConsumed(t *testing.T) {
+	parser := NewParser()
+	expected := []Message{{
+		Role:        "assistant",
+		Channel:     ChannelCommentary,
+		Content:     `{"a":1}`,
+		To:          "functions.x",
+		Constrain:   "json",
+		IsCall:      true,
+		ArgsAreJSON: true,
+		Complete:    true,
+	}}
+
+	inputs := []string{
+		`<|channel|>commentary to=functions.x <|constrain|>json<|message|>{"a":1}<|call|>`,
+		`<|channel|>commentary to=functions.x<|constrain|> json<|message|>{"a":1}<|call|>`,
+		`<|channel|>commentary to=functions.x <|constrain|>json <|message|>{"a":1}<|call|>`,
+		`<|start|>assistant<|channel|>commentary to=functions.x <|constrain|>json<|message|>{"a":1}<|call|>`,
+	}
+	for _, input := range inputs {
+		messages, err := parser.ParseResponse(input)
+		if err != nil {
+			t.Fatalf("ParseResponse() error = %v", err)
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Errorf("ParseResponse(%q) = %v, want %v", input, messages, expected)
+		}
+	}
+}
+
+func TestExtractFinalMessage(t *testing.T) {
+	parser := NewParser()
+	
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Extract from mixed channels",
+			input:    `<|channel|>analysis<|message|>Thinking...<|end|><|channel|>final<|message|>Hello!<|end|>`,
+			expected: "Hello!",
+		},
+		{
+			name:     "No final channel",
+			input:    `<|channel|>analysis<|message|>Just analysis<|end|>`,
+			expected: "",
+		},
+		{
+			name:     "Plain text (non-strict mode)",
+			input:    "Plain text message",
+			expected: "Plain text message",
+		},
+		{
+			name:     "Skip function calls",
+			input:    `<|channel|>final<|message|>FUNCTION_CALL: test()<|end|>`,
+			expected: "",
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.ExtractFinalMessage(tt.input)
+			if result != tt.expected {
+				t.Errorf("ExtractFinalMessage() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractFinalMessage_SuppressJSONFinal(t *testing.T) {
+	config := DefaultConfig()
+	config.SuppressJSONFinal = true
+	parser := NewParserWithConfig(config)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "JSON-only final",
+			input:    `<|channel|>final<|message|>{"location": "NYC"}<|end|>`,
+			expected: "",
+		},
+		{
+			name:     "Skips JSON final for a later text final",
+			input:    `<|channel|>final<|message|> [1, 2] <|end|><|channel|>final<|message|>It's sunny<|end|>`,
+			expected: "It's sunny",
+		},
+		{
+			name:     "Scalar answer is kept",
+			input:    `<|channel|>final<|message|>42<|end|>`,
+			expected: "42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.ExtractFinalMessage(tt.input)
+			if result != tt.expected {
+				t.Errorf("ExtractFinalMessage() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+
+	if result := NewParser().ExtractFinalMessage(tests[0].input); result != `{"location": "NYC"}` {
+		t.Errorf("ExtractFinalMessage() default = %v, want JSON content", result)
+	}
+}
+
+func TestExtractFinalMessage_SynthesizeFinalFromAnalysis(t *testing.T) {
+	input := `<|channel|>analysis<|message|>Considering options<|end|>
+<|channel|>analysis<|message|>Probably option B<|end|>`
+
+	config := DefaultConfig()
+	config.SynthesizeFinalFromAnalysis = true
+	if result := NewParserWithConfig(config).ExtractFinalMessage(input); result != "Probably option B" {
+		t.Errorf("ExtractFinalMessage() = %v, want last analysis", result)
+	}
+
+	config.SynthesizedFinal = "Sorry, I couldn't finish that answer."
+	parser := NewParserWithConfig(config)
+	if result := parser.ExtractFinalMessage(input); result != config.SynthesizedFinal {
+		t.Errorf("ExtractFinalMessage() = %v, want %v", result, config.SynthesizedFinal)
+	}
+	if result := parser.ExtractFinalMessage(input + "<|channel|>final<|message|>B<|end|>"); result != "B" {
+		t.Errorf("ExtractFinalMessage() = %v, want the real final", result)
+	}
+
+	if result := NewParser().ExtractFinalMessage(input); result != "" {
+		t.Errorf("ExtractFinalMessage() default = %v, want empty", result)
+	}
+}
+
+func TestAnswerWithReasoning(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name              string
+		input             string
+		expectedAnswer    string
+		expectedReasoning string
+	}{
+		{
+			name: "Mixed transcript",
+			input: `<|channel|>analysis<|message|>User wants weather<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>analysis<|message|>It's sunny<|end|>
+<|channel|>final<|message|>It's sunny in NYC<|end|>`,
+			expectedAnswer:    "It's sunny in NYC",
+			expectedReasoning: "User wants weather\nIt's sunny",
+		},
+		{
+			name:              "Analysis only",
+			input:             `<|channel|>analysis<|message|>Still thinking<|end|>`,
+			expectedReasoning: "Still thinking",
+		},
+		{
+			name:  "Empty",
+			input: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, reasoning := parser.AnswerWithReasoning(tt.input)
+			if answer != tt.expectedAnswer || reasoning != tt.expectedReasoning {
+				t.Errorf("AnswerWithReasoning() = (%q, %q), want (%q, %q)", answer, reasoning, tt.expectedAnswer, tt.expectedReasoning)
+			}
+		})
+	}
+}
+
+func TestExtractFunctionCall(t *testing.T) {
+	parser := NewParser()
+	
+	tests := []struct {
+		name         string
+		input        string
+		expectedName string
+		expectedArgs string
+		expectedOK   bool
+	}{
+		{
+			name:         "Harmony format",
+			input:        `<|channel|>commentary to=functions.calculate<|message|>{"x": 5}<|call|>`,
+			expectedName: "calculate",
+			expectedArgs: `{"x": 5}`,
+			expectedOK:   true,
+		},
+		{
+			name:         "FUNCTION_CALL format",
+			input:        `FUNCTION_CALL: calculate({"x": 5})`,
+			expectedName: "calculate",
+			expectedArgs: `{"x": 5}`,
+			expectedOK:   true,
+		},
+		{
+			name:         "No function call",
+			input:        `<|channel|>final<|message|>Regular message<|end|>`,
+			expectedName: "",
+			expectedArgs: "",
+			expectedOK:   false,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, args, ok := parser.ExtractFunctionCall(tt.input)
+			if name != tt.expectedName || args != tt.expectedArgs || ok != tt.expectedOK {
+				t.Errorf("ExtractFunctionCall() = (%v, %v, %v), want (%v, %v, %v)",
+					name, args, ok, tt.expectedName, tt.expectedArgs, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestExtractFunctionCall_RequireCompleteCallArgs(t *testing.T) {
+	config := DefaultConfig()
+	config.RequireCompleteCallArgs = true
+	parser := NewParserWithConfig(config)
+
+	complete := `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`
+	partial := `<|channel|>commentary to=functions.get_weather<|message|>{"location": "N<|call|>`
+
+	name, args, found := parser.ExtractFunctionCall(complete)
+	if !found || name != "get_weather" || args != `{"location": "NYC"}` {
+		t.Errorf("ExtractFunctionCall() complete = (%v, %v, %v), want get_weather call", name, args, found)
+	}
+
+	if _, _, found := parser.ExtractFunctionCall(partial); found {
+		t.Error("ExtractFunctionCall() returned a call with partial args")
+	}
+	messages, err := parser.ParseResponse(partial)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if len(messages) != 1 || !messages[0].IsCall || messages[0].Complete {
+		t.Errorf("ParseResponse() partial = %+v, want an incomplete call", messages)
+	}
+
+	// Without the option partial args are still returned
+	if _, args, found := NewParser().ExtractFunctionCall(partial); !found || args != `{"location": "N` {
+		t.Errorf("ExtractFunctionCall() default = (%v, %v), want partial args", args, found)
+	}
+}
+
+func TestGetChannelContent(t *testing.T) {
+	parser := NewParser()
+	
+	input := `<|channel|>analysis<|message|>First analysis<|end|>
+<|channel|>final<|message|>User message<|end|>
+<|channel|>analysis<|message|>Second analysis<|end|>`
+	
+	tests := []struct {
+		name     string
+		channel  Channel
+		expected []string
+	}{
+		{
+			name:     "Get analysis content",
+			channel:  ChannelAnalysis,
+			expected: []string{"First analysis", "Second analysis"},
+		},
+		{
+			name:     "Get final content",
+			channel:  ChannelFinal,
+			expected: []string{"User message"},
+		},
+		{
+			name:     "Get commentary content",
+			channel:  ChannelCommentary,
+			expected: nil,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.GetChannelContent(input, tt.channel)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("GetChannelContent() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetChannelContent_MatchesFullParse(t *testing.T) {
+	inputs := []string{
+		`<|channel|>analysis<|message|>  Padded  <|end|>
+<|start|>assistant<|message|>No channel<|end|>
+<|channel|>commentary to=functions.x <|constrain|>json<|message|>{"a":1}<|call|>
+<|channel|>final_v2<|message|>Aliased<|end|>
+<|channel|>final<|message|>Unterminated`,
+		"\uFEFF```harmony\n<|channel|>final<|message|>Fenced<|end|>\n```",
+		`<|channel|>analysis<|message|>Unterminated analysis`,
+		"<|channel|>final\n<|message|>Split header<|end|>",
+		`FUNCTION_CALL: search({"q": "news"})`,
+		"Plain text",
+		"",
+	}
+	configs := map[string]func(*ParserConfig){
+		"Default": nil,
+		"Configured": func(c *ParserConfig) {
+			c.DefaultChannel = ChannelFinal
+			c.ChannelAliases = map[string]Channel{"final_v2": ChannelFinal}
+			c.StripCodeFences = true
+			c.Tolerant = true
+		},
+		"Strict": func(c *ParserConfig) { c.StrictMode = true },
+	}
+	channels := append(AllChannels(), ChannelNone)
+
+	for name, modify := range configs {
+		parser := NewParser().Clone(modify)
+		for _, input := range inputs {
+			for _, channel := range channels {
+				var expected []string
+				if messages, err := parser.ParseResponse(input); err == nil {
+					for _, msg := range messages {
+						if msg.Channel == channel {
+							expected = append(expected, msg.Content)
+						}
+					}
+				}
+				if result := parser.GetChannelContent(input, channel); !reflect.DeepEqual(result, expected) {
+					t.Errorf("%s: GetChannelContent(%q, %s) = %q, want %q", name, input, channel, result, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestChannelMap(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>First thought<|end|>
+<|channel|>commentary<|message|>Checking<|end|>
+<|channel|>analysis<|message|>Second thought<|end|>
+<|channel|>final<|message|>Answer<|end|>`
+
+	expected := map[Channel]string{
+		ChannelAnalysis:   "First thought\nSecond thought",
+		ChannelCommentary: "Checking",
+		ChannelFinal:      "Answer",
+	}
+	if result := parser.ChannelMap(input); !reflect.DeepEqual(result, expected) {
+		t.Errorf("ChannelMap() = %v, want %v", result, expected)
+	}
+}
+
+func TestContentBetween(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>Thinking<|end|> stray junk text <|channel|>final<|message|>Answer<|end|>`
+
+	tests := []struct {
+		name     string
+		input    string
+		from, to Channel
+		expected string
+	}{
+		{name: "Junk between analysis and final", input: input, from: ChannelAnalysis, to: ChannelFinal, expected: " stray junk text "},
+		{name: "Missing channel", input: input, from: ChannelCommentary, to: ChannelFinal, expected: ""},
+		{name: "Reversed order", input: input, from: ChannelFinal, to: ChannelAnalysis, expected: ""},
+		{
+			name:     "Word before a bare channel",
+			input:    `<|channel|>analysis<|message|>Thinking<|end|> junk word<|channel|>final<|message|>Answer<|end|>`,
+			from:     ChannelAnalysis,
+			to:       ChannelFinal,
+			expected: " junk word",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.ContentBetween(tt.input, tt.from, tt.to)
+			if result != tt.expected {
+				t.Errorf("ContentBetween() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseResponse_ChannelAliases(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictMode = true
+	config.ChannelAliases = map[string]Channel{"final_v2": ChannelFinal}
+	parser := NewParserWithConfig(config)
+
+	inputs := []string{
+		`<|start|>assistant<|channel|>final_v2<|message|>Hello<|end|>`,
+		`<|channel|>final_v2<|message|>Hello<|end|>`,
+	}
+	expected := []Message{{Role: "assistant", Channel: ChannelFinal, Content: "Hello"}}
+
+	for _, input := range inputs {
+		messages, err := parser.ParseResponse(input)
+		if err != nil {
+			t.Fatalf("ParseResponse() error = %v", err)
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+		}
+	}
+}
+
+func TestParseResponse_Offsets(t *testing.T) {
+	input := "\uFEFF<|channel|>analysis<|message|>Café 日本<|end|>\n<|channel|>final<|message|>🎉 done<|end|>"
+
+	config := DefaultConfig()
+	config.RecordOffsets = true
+	config.RecordRuneOffsets = true
+	messages, err := NewParserWithConfig(config).ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("ParseResponse() = %v, want 2 messages", messages)
+	}
+
+	runes := []rune(input)
+	for _, msg := range messages {
+		raw := input[msg.Start:msg.End]
+		if string(runes[msg.StartRune:msg.EndRune]) != raw {
+			t.Errorf("rune span %d-%d = %q, want %q", msg.StartRune, msg.EndRune, string(runes[msg.StartRune:msg.EndRune]), raw)
+		}
+		if !strings.HasPrefix(raw, "<|channel|>") || !strings.HasSuffix(raw, "<|end|>") {
+			t.Errorf("byte span %d-%d = %q, want the whole message", msg.Start, msg.End, raw)
+		}
+	}
+
+	// The BOM and multi-byte content push byte offsets ahead of rune offsets
+	second := messages[1]
+	if second.Start != 53 || second.End != 96 || second.StartRune != 46 || second.EndRune != 86 {
+		t.Errorf("final offsets = bytes %d-%d, runes %d-%d", second.Start, second.End, second.StartRune, second.EndRune)
+	}
+
+	// Offsets skip the opening fence removed by StripCodeFences
+	fenced := "```harmony\n<|channel|>final<|message|>Hi<|end|>\n```"
+	fenceConfig := config
+	fenceConfig.StripCodeFences = true
+	messages, err = NewParserWithConfig(fenceConfig).ParseResponse(fenced)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if len(messages) != 1 || messages[0].Start != 11 || fenced[messages[0].Start:messages[0].End] != "<|channel|>final<|message|>Hi<|end|>" {
+		t.Errorf("fenced offsets = %+v, want the span of the message inside the fence", messages)
+	}
+
+	// ParseResponseOpaque offsets count fenced regions at their full length
+	opaque := "<|start|>developer<|message|>See ~~~<|channel|>final<|message|>Café<|end|>~~~<|end|>\n<|start|>user<|message|>Hé<|end|>"
+	messages, err = NewParserWithConfig(config).ParseResponseOpaque(opaque, "~~~")
+	if err != nil {
+		t.Fatalf("ParseResponseOpaque() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("ParseResponseOpaque() = %v, want 2 messages", messages)
+	}
+	opaqueRunes := []rune(opaque)
+	for _, msg := range messages {
+		raw := opaque[msg.Start:msg.End]
+		if !strings.HasPrefix(raw, "<|start|>") || !strings.HasSuffix(raw, "<|end|>") {
+			t.Errorf("opaque byte span %d-%d = %q, want the whole message", msg.Start, msg.End, raw)
+		}
+		if string(opaqueRunes[msg.StartRune:msg.EndRune]) != raw {
+			t.Errorf("opaque rune span %d-%d = %q, want %q", msg.StartRune, msg.EndRune, string(opaqueRunes[msg.StartRune:msg.EndRune]), raw)
+		}
+	}
+
+	// Offsets are not recorded by default
+	messages, _ = NewParser().ParseResponse(input)
+	if messages[1].Start != 0 || messages[1].StartRune != 0 {
+		t.Errorf("ParseResponse() recorded offsets without RecordOffsets: %+v", messages[1])
+	}
+}
+
+func TestParseResponse_ContentTransform(t *testing.T) {
+	config := DefaultConfig()
+	config.ContentTransform = func(channel Channel, content string) string {
+		if channel == ChannelFinal {
+			return strings.ToUpper(content)
+		}
+		return content
+	}
+	parser := NewParserWithConfig(config)
+
+	input := `<|channel|>analysis<|message|>Thinking quietly<|end|>
+<|channel|>final<|message|>Hello there<|end|>`
+
+	expected := []Message{
+		{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking quietly"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "HELLO THERE"},
+	}
+	messages, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+	}
+}
+
+func TestParseResponse_StripRolePrefix(t *testing.T) {
+	config := DefaultConfig()
+	config.StripRolePrefix = true
+	parser := NewParserWithConfig(config)
+
+	input := `<|channel|>analysis<|message|>assistant: thinking<|end|>
+<|channel|>final<|message|>assistant: Hello<|end|>
+<|start|>assistant<|channel|>final<|message|>Assistant:Hi again<|end|>
+<|start|>user<|message|>assistant: is this kept?<|end|>`
+
+	expected := []Message{
+		{Role: "assistant", Channel: ChannelAnalysis, Content: "assistant: thinking"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Hi again"},
+		{Role: "user", Channel: ChannelNone, Content: "assistant: is this kept?"},
+	}
+	messages, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+	}
+}
+
+func TestParseResponse_StrayMessageToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []Message
+	}{
+		{
+			name:  "Stray token between messages",
+			input: `<|start|>user<|message|>Question<|end|>note<|message|>stray <|channel|>final<|message|>Answer<|end|>`,
+			expected: []Message{
+				{Role: "user", Channel: ChannelNone, Content: "Question"},
+				{Role: "assistant", Channel: ChannelFinal, Content: "Answer"},
+			},
+		},
+		{
+			name:  "Stray token before an unterminated final",
+			input: `<|channel|>analysis<|message|>a<|end|> see <|message|> x <|channel|>final<|message|>Hello`,
+			expected: []Message{
+				{Role: "assistant", Channel: ChannelAnalysis, Content: "a"},
+				{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := NewParser().ParseResponse(tt.input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("ParseResponse() = %v, want %v", messages, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseResponse_MixedFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []Message
+	}{
+		{
+			name: "Full block then bare simplified block",
+			input: `<|start|>assistant<|channel|>analysis<|message|>Thinking<|end|>
+<|channel|>final<|message|>Answer<|end|>`,
+			expected: []Message{
+				{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking"},
+				{Role: "assistant", Channel: ChannelFinal, Content: "Answer"},
+			},
+		},
+		{
+			name: "Channel-less full block then bare simplified block",
+			input: `<|start|>user<|message|>Question<|end|>
+<|channel|>final<|message|>Answer<|end|>`,
+			expected: []Message{
+				{Role: "user", Channel: ChannelNone, Content: "Question"},
+				{Role: "assistant", Channel: ChannelFinal, Content: "Answer"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := NewParser().ParseResponse(tt.input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+			if !reflect.DeepEqual(messages, tt.expected) {
+				t.Errorf("ParseResponse() = %v, want %v", messages, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseResponse_StrayMessageTokensLinear(t *testing.T) {
+	input := strings.Repeat("<|message|>", 8000)
+
+	start := time.Now()
+	messages, err := NewParser().ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ParseResponse() took %s on %d bytes of stray tokens, want linear time", elapsed, len(input))
+	}
+	if len(messages) != 1 || messages[0].Content != input {
+		t.Errorf("ParseResponse() = %d messages, want the input as a single plain message", len(messages))
+	}
+}
+
+func TestChannelMetadata(t *testing.T) {
+	tests := []struct {
+		channel     Channel
+		displayName string
+		userVisible bool
+	}{
+		{ChannelAnalysis, "Analysis", false},
+		{ChannelCommentary, "Commentary", false},
+		{ChannelFinal, "Final", true},
+		{ChannelNone, "None", false},
+		{Channel("custom"), "custom", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.displayName, func(t *testing.T) {
+			if got := tt.channel.DisplayName(); got != tt.displayName {
+				t.Errorf("DisplayName() = %v, want %v", got, tt.displayName)
+			}
+			if got := tt.channel.IsUserVisible(); got != tt.userVisible {
+				t.Errorf("IsUserVisible() = %v, want %v", got, tt.userVisible)
+			}
+		})
+	}
+
+	expected := []Channel{ChannelAnalysis, ChannelCommentary, ChannelFinal}
+	if got := AllChannels(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("AllChannels() = %v, want %v", got, expected)
+	}
+}
+
+func TestParseResponseLimit(t *testing.T) {
+	parser := NewParser()
+	input := `<|start|>user<|message|>Question<|end|>
+<|channel|>analysis<|message|>First<|end|>
+<|channel|>commentary<|message|>Second<|end|>
+<|channel|>final<|message|>Third<|end|>`
+
+	full, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	for n := 0; n <= len(full)+1; n++ {
+		messages, err := parser.ParseResponseLimit(input, n)
+		if err != nil {
+			t.Fatalf("ParseResponseLimit() error = %v", err)
+		}
+		want := n
+		if want > len(full) {
+			want = len(full)
+		}
+		if len(messages) != want || (want > 0 && !reflect.DeepEqual(messages, full[:want])) {
+			t.Errorf("ParseResponseLimit(%d) = %v, want %v", n, messages, full[:want])
+		}
+	}
+
+	legacy := `FUNCTION_CALL: a({"x": 1}) FUNCTION_CALL: b({"y": 2})`
+	if messages, _ := parser.ParseResponseLimit(legacy, 1); len(messages) != 1 {
+		t.Errorf("ParseResponseLimit() legacy = %v, want 1 message", messages)
+	}
+}
+
+func TestParseMultiple(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>final<|message|>First<|end|>
+---
+<|channel|>analysis<|message|>Thinking<|end|><|channel|>final<|message|>Second<|end|>
+---
+
+---
+<|channel|>final<|message|>Third<|end|>`
+
+	expected := [][]Message{
+		{{Role: "assistant", Channel: ChannelFinal, Content: "First"}},
+		{
+			{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking"},
+			{Role: "assistant", Channel: ChannelFinal, Content: "Second"},
+		},
+		{{Role: "assistant", Channel: ChannelFinal, Content: "Third"}},
+	}
+
+	responses, err := parser.ParseMultiple(input, "\n---\n")
+	if err != nil {
+		t.Fatalf("ParseMultiple() error = %v", err)
+	}
+	if !reflect.DeepEqual(responses, expected) {
+		t.Errorf("ParseMultiple() = %v, want %v", responses, expected)
+	}
+}
+
+func TestParseResponseOpaque(t *testing.T) {
+	parser := NewParser()
+	input := `<|start|>developer<|message|>Answer like this example:
+~~~
+<|start|>user<|message|>Hi<|end|><|start|>assistant<|channel|>final<|message|>Hello!<|end|>
+~~~
+Keep it short.<|end|>
+<|start|>user<|message|>Hey there<|end|>`
+
+	// Without fences the embedded sample is split into messages of its own
+	messages, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if len(messages) == 2 {
+		t.Fatalf("ParseResponse() = %v, expected the embedded sample to be mis-split", messages)
+	}
+
+	expected := []Message{
+		{Role: "developer", Channel: ChannelNone, Content: `Answer like this example:
+~~~
+<|start|>user<|message|>Hi<|end|><|start|>assistant<|channel|>final<|message|>Hello!<|end|>
+~~~
+Keep it short.`},
+		{Role: "user", Channel: ChannelNone, Content: "Hey there"},
+	}
+	messages, err = parser.ParseResponseOpaque(input, "~~~")
+	if err != nil {
+		t.Fatalf("ParseResponseOpaque() error = %v", err)
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponseOpaque() = %v, want %v", messages, expected)
+	}
+}
+
+func TestParseResponseReversed(t *testing.T) {
+	parser := NewParser()
+
+	input := `<|channel|>analysis<|message|>First<|end|>
+<|channel|>commentary<|message|>Second<|end|>
+<|channel|>final<|message|>Third<|end|>`
+
+	messages, err := parser.ParseResponseReversed(input)
+	if err != nil {
+		t.Fatalf("ParseResponseReversed() error = %v", err)
+	}
+
+	expected := []Message{
+		{Role: "assistant", Channel: ChannelFinal, Content: "Third"},
+		{Role: "assistant", Channel: ChannelCommentary, Content: "Second"},
+		{Role: "assistant", Channel: ChannelAnalysis, Content: "First"},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponseReversed() = %v, want %v", messages, expected)
+	}
+}
+
+func TestIsPlainText(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{"Plain prose", "The weather in NYC is sunny, with a high of 72°F.", true},
+		{"Empty", "", true},
+		{"Lookalike brackets", "Use <|name|> placeholders in templates", true},
+		{"Stray token", "The answer is 42<|end|>", false},
+		{"Channel block", "<|channel|>final<|message|>Hi<|end|>", false},
+		{"Legacy call", `FUNCTION_CALL: search({"q": "news"})`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := parser.IsPlainText(tt.input); result != tt.expected {
+				t.Errorf("IsPlainText() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected Format
+	}{
+		{
+			name:     "Full Harmony",
+			input:    `<|start|>assistant<|channel|>final<|message|>Hello<|end|>`,
+			expected: FormatHarmonyFull,
+		},
+		{
+			name: "Full Harmony after simple block",
+			input: `<|channel|>analysis<|message|>Thinking<|end|>
+<|start|>assistant<|channel|>final<|message|>Hello<|end|>`,
+			expected: FormatHarmonyFull,
+		},
+		{
+			name:     "Simplified channels",
+			input:    `<|channel|>final<|message|>Hello<|end|>`,
+			expected: FormatHarmonySimple,
+		},
+		{
+			name:     "Legacy function call",
+			input:    `FUNCTION_CALL: get_weather({"location": "NYC"})`,
+			expected: FormatFunctionCall,
+		},
+		{
+			name:     "Plain text",
+			input:    "Just some text",
+			expected: FormatPlain,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.DetectFormat(tt.input)
+			if result != tt.expected {
+				t.Errorf("DetectFormat() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetChannelMessages(t *testing.T) {
+	parser := NewParser()
+
+	input := `<|channel|>analysis<|message|>Need weather data<|end|>
+<|channel|>commentary<|message|>Checking the forecast<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>final<|message|>It's sunny<|end|>`
+
+	expected := []Message{
+		{Role: "assistant", Channel: ChannelCommentary, Content: "Checking the forecast"},
+		{Role: "assistant", Channel: ChannelCommentary, Content: `{"location": "NYC"}`, To: "functions.get_weather", IsCall: true, ArgsAreJSON: true, Complete: true},
+	}
+
+	result := parser.GetChannelMessages(input, ChannelCommentary)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("GetChannelMessages() = %v, want %v", result, expected)
+	}
+}
+
+func TestExtractReasoningSummary(t *testing.T) {
+	input := `<|channel|>analysis<|message|>The user wants the weather. I should look up NYC and report the temperature.<|end|>
+<|channel|>analysis<|message|>Look up NYC weather.<|end|>
+<|channel|>analysis<|message|>Then report the result in Fahrenheit.<|end|>
+<|channel|>final<|message|>It's sunny<|end|>`
+
+	tests := []struct {
+		strategy SummaryStrategy
+		expected string
+	}{
+		{strategy: "", expected: "Then report the result in Fahrenheit."},
+		{strategy: SummaryLast, expected: "Then report the result in Fahrenheit."},
+		{strategy: SummaryShortest, expected: "Look up NYC weather."},
+	}
+
+	for _, tt := range tests {
+		config := DefaultConfig()
+		config.SummaryStrategy = tt.strategy
+		result := NewParserWithConfig(config).ExtractReasoningSummary(input)
+		if result != tt.expected {
+			t.Errorf("ExtractReasoningSummary() with %q = %v, want %v", tt.strategy, result, tt.expected)
+		}
+	}
+}
+
+func TestExtractCommentaryText(t *testing.T) {
+	parser := NewParser()
+
+	input := `<|channel|>analysis<|message|>Need weather data<|end|>
+<|channel|>commentary<|message|>Let me check the forecast<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|channel|>final<|message|>It's sunny<|end|>`
+
+	expected := []string{"Let me check the forecast"}
+	result := parser.ExtractCommentaryText(input)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ExtractCommentaryText() = %v, want %v", result, expected)
+	}
+}
+
+func TestExtractStatusLine(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "Status line before final",
+			input: `<|channel|>analysis<|message|>Need weather data<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|channel|>commentary<|message|>Checking the forecast…<|end|>
+<|channel|>commentary<|message|>Almost done<|end|>
+<|channel|>final<|message|>It's sunny<|end|>`,
+			expected: "Checking the forecast…",
+		},
+		{
+			name: "Still generating",
+			input: `<|channel|>commentary<|message|>Searching the web<|end|>
+<|channel|>final<|message|>Here`,
+			expected: "Searching the web",
+		},
+		{
+			name: "Commentary after final",
+			input: `<|channel|>final<|message|>It's sunny<|end|>
+<|channel|>commentary<|message|>Done<|end|>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := parser.ExtractStatusLine(tt.input); result != tt.expected {
+				t.Errorf("ExtractStatusLine() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResponseKind(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected ResponseKind
+	}{
+		{
+			name: "Tool call",
+			input: `<|channel|>analysis<|message|>Need weather<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`,
+			expected: KindToolCall,
+		},
+		{
+			name: "Final answer",
+			input: `<|channel|>analysis<|message|>Easy one<|end|>
+<|channel|>final<|message|>42<|end|>`,
+			expected: KindFinalAnswer,
+		},
+		{
+			name:     "Reasoning only",
+			input:    `<|channel|>analysis<|message|>Still thinking<|end|>`,
+			expected: KindReasoningOnly,
+		},
+		{
+			name:     "Empty",
+			input:    "",
+			expected: KindEmpty,
+		},
+		{
+			name:     "Whitespace final",
+			input:    `<|channel|>final<|message|>   <|end|>`,
+			expected: KindEmpty,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.ResponseKind(tt.input)
+			if result != tt.expected {
+				t.Errorf("ResponseKind() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>User wants weather<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>final<|message|>It's sunny in NYC<|end|>`
+
+	visible, hidden := parser.Split(input)
+	if visible != "It's sunny in NYC" {
+		t.Errorf("Split() visible = %q, want %q", visible, "It's sunny in NYC")
+	}
+	expectedHidden := "User wants weather\n{\"location\": \"NYC\"}"
+	if hidden != expectedHidden {
+		t.Errorf("Split() hidden = %q, want %q", hidden, expectedHidden)
+	}
+	if strings.Contains(visible, "weather") || strings.Contains(hidden, "sunny") {
+		t.Errorf("Split() parts overlap: visible=%q hidden=%q", visible, hidden)
+	}
+}
+
+func TestAnalysisRecipients(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis to=agent.planner<|message|>Plan the trip<|end|>
+<|channel|>analysis<|message|>Unaddressed reasoning<|end|>
+<|channel|>analysis to=agent.critic<|message|>Review the plan<|end|>
+<|channel|>analysis to=agent.planner<|message|>Revise the plan<|end|>`
+
+	messages := parser.GetChannelMessages(input, ChannelAnalysis)
+	if len(messages) != 4 || messages[0].To != "agent.planner" || messages[0].IsCall {
+		t.Fatalf("GetChannelMessages() = %v, want addressed analysis messages", messages)
+	}
+
+	expected := []string{"agent.planner", "agent.critic"}
+	if result := parser.AnalysisRecipients(input); !reflect.DeepEqual(result, expected) {
+		t.Errorf("AnalysisRecipients() = %v, want %v", result, expected)
+	}
+}
+
+func TestHasChannel(t *testing.T) {
+	parser := NewParser()
+	
+	input := `<|channel|>analysis<|message|>Test<|end|>
+<|channel|>final<|message|>Test<|end|>`
+	
+	if !parser.HasChannel(input, ChannelAnalysis) {
+		t.Error("HasChannel() should return true for analysis channel")
+	}
+	if !parser.HasChannel(input, ChannelFinal) {
+		t.Error("HasChannel() should return true for final channel")
+	}
+	if parser.HasChannel(input, ChannelCommentary) {
+		t.Error("HasChannel() should return false for commentary channel")
+	}
+}
+
+func TestChannelsPresent(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>Need data<|end|>
+<|channel|>commentary to=functions.search<|message|>{"q": "news"}<|call|>
+<|channel|>analysis<|message|>Got it<|end|>
+<|channel|>final<|message|>Here you go<|end|>`
+
+	expected := []Channel{ChannelAnalysis, ChannelCommentary, ChannelFinal}
+	if channels := parser.ChannelsPresent(input); !reflect.DeepEqual(channels, expected) {
+		t.Errorf("ChannelsPresent() = %v, want %v", channels, expected)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
 	parser := NewParser()
-	
+
 	tests := []struct {
 		name     string
 		input    string
-		expected string
+		expected bool
 	}{
-		{
-			name:     "Extract from mixed channels",
-			input:    `<|channel|>analysis<|message|>Thinking...<|end|><|channel|>final<|message|>Hello!<|end|>`,
-			expected: "Hello!",
-		},
-		{
-			name:     "No final channel",
-			input:    `<|channel|>analysis<|message|>Just analysis<|end|>`,
-			expected: "",
-		},
-		{
-			name:     "Plain text (non-strict mode)",
-			input:    "Plain text message",
-			expected: "Plain text message",
-		},
-		{
-			name:     "Skip function calls",
-			input:    `<|channel|>final<|message|>FUNCTION_CALL: test()<|end|>`,
-			expected: "",
-		},
+		{name: "Truly empty", input: "", expected: true},
+		{name: "Whitespace only", input: " \n\t ", expected: true},
+		{name: "Whitespace-only final", input: "<|channel|>final<|message|>   \n<|end|>", expected: true},
+		{name: "Empty analysis and final", input: "<|channel|>analysis<|message|><|end|><|channel|>final<|message|> <|end|>", expected: true},
+		{name: "Non-empty final", input: "<|channel|>final<|message|>Hello<|end|>", expected: false},
+		{name: "Tool call only", input: `<|channel|>commentary to=functions.x<|message|>{}<|call|>`, expected: false},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := parser.ExtractFinalMessage(tt.input)
-			if result != tt.expected {
-				t.Errorf("ExtractFinalMessage() = %v, want %v", result, tt.expected)
+			if result := parser.IsEmpty(tt.input); result != tt.expected {
+				t.Errorf("IsEmpty() = %v, want %v", result, tt.expected)
 			}
 		})
 	}
 }
 
-func TestExtractFunctionCall(t *testing.T) {
+func TestVisibleLength(t *testing.T) {
 	parser := NewParser()
-	
+
 	tests := []struct {
-		name         string
-		input        string
-		expectedName string
-		expectedArgs string
-		expectedOK   bool
+		name     string
+		input    string
+		expected int
 	}{
 		{
-			name:         "Harmony format",
-			input:        `<|channel|>commentary to=functions.calculate<|message|>{"x": 5}<|call|>`,
-			expectedName: "calculate",
-			expectedArgs: `{"x": 5}`,
-			expectedOK:   true,
+			name: "Excludes hidden channels",
+			input: `<|channel|>analysis<|message|>Long internal reasoning<|end|>
+<|channel|>final<|message|>Hello<|end|>`,
+			expected: 5,
 		},
 		{
-			name:         "FUNCTION_CALL format",
-			input:        `FUNCTION_CALL: calculate({"x": 5})`,
-			expectedName: "calculate",
-			expectedArgs: `{"x": 5}`,
-			expectedOK:   true,
+			name:     "Multi-byte characters",
+			input:    `<|channel|>final<|message|>Café 日本 🎉<|end|>`,
+			expected: 9,
 		},
 		{
-			name:         "No function call",
-			input:        `<|channel|>final<|message|>Regular message<|end|>`,
-			expectedName: "",
-			expectedArgs: "",
-			expectedOK:   false,
+			name:     "No final channel",
+			input:    `<|channel|>analysis<|message|>Just analysis<|end|>`,
+			expected: 0,
 		},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			name, args, ok := parser.ExtractFunctionCall(tt.input)
-			if name != tt.expectedName || args != tt.expectedArgs || ok != tt.expectedOK {
-				t.Errorf("ExtractFunctionCall() = (%v, %v, %v), want (%v, %v, %v)",
-					name, args, ok, tt.expectedName, tt.expectedArgs, tt.expectedOK)
+			result := parser.VisibleLength(tt.input)
+			if result != tt.expected {
+				t.Errorf("VisibleLength() = %v, want %v", result, tt.expected)
 			}
 		})
 	}
 }
 
-func TestGetChannelContent(t *testing.T) {
+func TestSummarize(t *testing.T) {
 	parser := NewParser()
-	
-	input := `<|channel|>analysis<|message|>First analysis<|end|>
-<|channel|>final<|message|>User message<|end|>
-<|channel|>analysis<|message|>Second analysis<|end|>`
-	
+
 	tests := []struct {
 		name     string
-		channel  Channel
-		expected []string
+		input    string
+		expected string
 	}{
 		{
-			name:     "Get analysis content",
-			channel:  ChannelAnalysis,
-			expected: []string{"First analysis", "Second analysis"},
+			name: "Mixed transcript",
+			input: `<|channel|>analysis<|message|>User wants weather<|end|>
+<|channel|>analysis<|message|>I should call the tool<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>final<|message|>It's sunny<|end|>`,
+			expected: "2 analysis, 1 call(get_weather), 1 final",
 		},
 		{
-			name:     "Get final content",
-			channel:  ChannelFinal,
-			expected: []string{"User message"},
+			name: "Messages without a channel",
+			input: `<|start|>user<|message|>Weather?<|end|>
+<|channel|>final<|message|>Sunny<|end|>`,
+			expected: "1 user, 1 final",
 		},
 		{
-			name:     "Get commentary content",
-			channel:  ChannelCommentary,
-			expected: nil,
+			name:     "Empty input",
+			input:    "",
+			expected: "",
 		},
 	}
-	
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := parser.GetChannelContent(input, tt.channel)
-			if !reflect.DeepEqual(result, tt.expected) {
-				t.Errorf("GetChannelContent() = %v, want %v", result, tt.expected)
+			result := parser.Summarize(tt.input)
+			if result != tt.expected {
+				t.Errorf("Summarize() = %v, want %v", result, tt.expected)
 			}
 		})
 	}
 }
 
-func TestHasChannel(t *testing.T) {
+func TestFlattenLabeled(t *testing.T) {
 	parser := NewParser()
-	
-	input := `<|channel|>analysis<|message|>Test<|end|>
-<|channel|>final<|message|>Test<|end|>`
-	
-	if !parser.HasChannel(input, ChannelAnalysis) {
-		t.Error("HasChannel() should return true for analysis channel")
+	input := `<|start|>user<|message|>Weather?<|end|>
+<|channel|>analysis<|message|>Need data<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>final<|message|>Sunny<|end|>`
+
+	expected := `[user/] Weather?
+[assistant/analysis] Need data
+[assistant/commentary] Function call to functions.get_weather: {"location": "NYC"}
+[assistant/final] Sunny`
+	if result := parser.FlattenLabeled(input); result != expected {
+		t.Errorf("FlattenLabeled() = %v, want %v", result, expected)
 	}
-	if !parser.HasChannel(input, ChannelFinal) {
-		t.Error("HasChannel() should return true for final channel")
+}
+
+func TestExtractCitations(t *testing.T) {
+	input := `<|channel|>analysis<|message|>Source [9] looks reliable<|end|>
+<|channel|>final<|message|>Paris is the capital [1] and has 2M people【2†wiki】.<|end|>`
+
+	citations := NewParser().ExtractCitations(input)
+	expected := []string{"[1]", "【2†wiki】"}
+	if !reflect.DeepEqual(citations, expected) {
+		t.Errorf("ExtractCitations() = %v, want %v", citations, expected)
 	}
-	if parser.HasChannel(input, ChannelCommentary) {
-		t.Error("HasChannel() should return false for commentary channel")
+
+	config := DefaultConfig()
+	config.CitationPattern = regexp.MustCompile(`\[\d+\]`)
+	citations = NewParserWithConfig(config).ExtractCitations(input)
+	if !reflect.DeepEqual(citations, []string{"[1]"}) {
+		t.Errorf("ExtractCitations() with custom pattern = %v, want [[1]]", citations)
 	}
 }
 
@@ -300,6 +1777,89 @@ func TestExtractJSON(t *testing.T) {
 	}
 }
 
+func TestExtractJSON_MaxJSONDepth(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxJSONDepth = 3
+	parser := NewParserWithConfig(config)
+
+	if _, err := parser.ExtractJSON(`{"a": {"b": [1, 2]}}`); err != nil {
+		t.Errorf("ExtractJSON() at the limit error = %v", err)
+	}
+
+	deep := `{"a": {"b": [{"c": 1}]}}`
+	if _, err := parser.ExtractJSON(deep); !errors.Is(err, ErrJSONTooDeep) {
+		t.Errorf("ExtractJSON() error = %v, want ErrJSONTooDeep", err)
+	}
+
+	// Deeply nested adversarial input is rejected without decoding it
+	adversarial := "{\"a\": " + strings.Repeat("[", 100000) + strings.Repeat("]", 100000) + "}"
+	if _, err := parser.ExtractJSON(adversarial); !errors.Is(err, ErrJSONTooDeep) {
+		t.Errorf("ExtractJSON() error = %v, want ErrJSONTooDeep", err)
+	}
+
+	// No limit by default
+	if _, err := NewParser().ExtractJSON(deep); err != nil {
+		t.Errorf("ExtractJSON() without MaxJSONDepth error = %v", err)
+	}
+}
+
+func TestExtractTrailingMetadata(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name       string
+		input      string
+		expected   map[string]interface{}
+		expectedOK bool
+	}{
+		{
+			name:       "Metadata footer",
+			input:      "<|channel|>final<|message|>answer<|end|>\n{\"confidence\":0.9}",
+			expected:   map[string]interface{}{"confidence": 0.9},
+			expectedOK: true,
+		},
+		{
+			name:  "No footer",
+			input: `<|channel|>final<|message|>answer {"x": 1}<|end|>`,
+		},
+		{
+			name:  "Non-JSON footer",
+			input: `<|channel|>final<|message|>answer<|end|> thanks`,
+		},
+		{
+			name:  "No terminator",
+			input: `{"confidence": 0.9}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, ok := parser.ExtractTrailingMetadata(tt.input)
+			if ok != tt.expectedOK || !reflect.DeepEqual(metadata, tt.expected) {
+				t.Errorf("ExtractTrailingMetadata() = (%v, %v), want (%v, %v)", metadata, ok, tt.expected, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestExtractChannelJSON(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>The user wants {weather} for {"city": "wrong"}<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`
+
+	result, err := parser.ExtractChannelJSON(input, ChannelCommentary)
+	if err != nil {
+		t.Fatalf("ExtractChannelJSON() error = %v", err)
+	}
+	if result["location"] != "NYC" || result["city"] != nil {
+		t.Errorf("ExtractChannelJSON() = %v, want commentary JSON only", result)
+	}
+
+	if _, err := parser.ExtractChannelJSON(input, ChannelFinal); err == nil {
+		t.Error("ExtractChannelJSON() expected error for channel without JSON")
+	}
+}
+
 func TestStrictMode(t *testing.T) {
 	config := ParserConfig{
 		StrictMode:  true,
@@ -363,6 +1923,226 @@ func TestMessageString(t *testing.T) {
 	}
 }
 
+func FuzzParseResponse(f *testing.F) {
+	seeds := []string{
+		`<|channel|>final<|message|>Hello world<|end|>`,
+		`<|channel|>analysis<|message|>Thinking...<|end|>
+<|channel|>final<|message|>Here's the answer<|end|>`,
+		`<|start|>system<|channel|>final<|message|>System message<|end|>`,
+		`<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`,
+		`<|channel|>commentary to=functions.x <|constrain|>json<|message|>{"a":1}<|call|>`,
+		`FUNCTION_CALL: get_weather({"location": "NYC"})`,
+		`<|channel|>final<|message|>FUNCTION_CALL: test()<|end|>`,
+		"Plain text message",
+		// Unbalanced and truncated tokens
+		`<|channel|>final<|message|>`,
+		`<|channel|><|message|><|end|>`,
+		`<|start|><|start|>assistant<|channel|>final`,
+		`<|channel|>final<|message|>a<|end|><|end|><|call|>`,
+		`<|end|><|channel|>analysis<|message|>x<|return|>`,
+		`FUNCTION_CALL: broken(`,
+		`<|`,
+		// Huge inputs
+		strings.Repeat(`<|channel|>analysis<|message|>x<|end|>`, 1000),
+		strings.Repeat("<|channel|>", 1000),
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	parser := NewParser()
+	f.Fuzz(func(t *testing.T, input string) {
+		messages, err := parser.ParseResponse(input)
+		if err != nil || !roundTrippable(messages) {
+			return
+		}
+
+		reparsed, err := parser.ParseResponse(Encode(messages))
+		if err != nil {
+			t.Fatalf("ParseResponse(Encode()) error = %v", err)
+		}
+		if !reflect.DeepEqual(reparsed, messages) {
+			t.Errorf("ParseResponse(Encode()) = %v, want %v", reparsed, messages)
+		}
+	})
+}
+
+// roundTrippable reports whether messages can survive Encode unchanged.
+// Content that embeds special tokens or untrimmed whitespace (plain text and
+// legacy fallbacks) is not expected to re-parse identically.
+func roundTrippable(messages []Message) bool {
+	if len(messages) == 0 {
+		return false
+	}
+	for _, msg := range messages {
+		if strings.Contains(msg.Content, "<|") || msg.Content != strings.TrimSpace(msg.Content) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMessageFormat(t *testing.T) {
+	msg := Message{
+		Role:        "assistant",
+		Channel:     ChannelCommentary,
+		Content:     `{"x": 5}`,
+		To:          "functions.calculate",
+		Constrain:   "json",
+		IsCall:      true,
+		ArgsAreJSON: true,
+		Complete:    true,
+	}
+	tool := Message{
+		Role:      "tool",
+		Channel:   ChannelCommentary,
+		Content:   `{"temp": 72}`,
+		ToolName:  "get_weather",
+		CallID:    "call_0",
+		Timestamp: "2024-01-01T00:00:00Z",
+	}
+
+	tests := []struct {
+		name     string
+		msg      Message
+		style    FormatStyle
+		expected string
+	}{
+		{
+			name:     "Compact",
+			msg:      msg,
+			style:    StyleCompact,
+			expected: `[assistant/commentary] Function call to functions.calculate: {"x": 5}`,
+		},
+		{
+			name:  "Verbose",
+			msg:   msg,
+			style: StyleVerbose,
+			expected: `role=assistant channel=commentary to=functions.calculate constrain=json tool_name= tool_call_id= ` +
+				`is_call=true args_are_json=true complete=true is_return=false timestamp= content="{\"x\": 5}"`,
+		},
+		{
+			name:  "Verbose tool message",
+			msg:   tool,
+			style: StyleVerbose,
+			expected: `role=tool channel=commentary to= constrain= tool_name=get_weather tool_call_id=call_0 ` +
+				`is_call=false args_are_json=false complete=false is_return=false timestamp=2024-01-01T00:00:00Z content="{\"temp\": 72}"`,
+		},
+		{
+			name:     "JSON line",
+			msg:      msg,
+			style:    StyleJSONLine,
+			expected: `{"role":"assistant","channel":"commentary","content":"{\"x\": 5}","to":"functions.calculate","constrain":"json","is_call":true,"args_are_json":true,"complete":true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.msg.Format(tt.style)
+			if result != tt.expected {
+				t.Errorf("Format() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+
+	if msg.String() != msg.Format(StyleCompact) {
+		t.Errorf("String() = %v, want compact format", msg.String())
+	}
+}
+
+func TestMessageWithChannelAndContent(t *testing.T) {
+	original := Message{Role: "assistant", Channel: ChannelFinal, Content: "Let me think... The answer is 42"}
+
+	moved := original.WithChannel(ChannelAnalysis).WithContent("Let me think...")
+
+	expected := Message{Role: "assistant", Channel: ChannelAnalysis, Content: "Let me think..."}
+	if !reflect.DeepEqual(moved, expected) {
+		t.Errorf("WithChannel().WithContent() = %v, want %v", moved, expected)
+	}
+	if original.Channel != ChannelFinal || original.Content != "Let me think... The answer is 42" {
+		t.Errorf("original message was modified: %v", original)
+	}
+}
+
+func TestMessageWithDefaultRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      Message
+		expected string
+	}{
+		{name: "Fills empty role", msg: Message{Channel: ChannelFinal, Content: "Hi"}, expected: "assistant"},
+		{name: "Keeps existing role", msg: Message{Role: "system", Channel: ChannelFinal, Content: "Hi"}, expected: "system"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.msg.WithDefaultRole("assistant")
+			if result.Role != tt.expected {
+				t.Errorf("WithDefaultRole() role = %v, want %v", result.Role, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMessageValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		msg         Message
+		expectError bool
+	}{
+		{
+			name: "Valid final",
+			msg:  Message{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+		},
+		{
+			name: "Valid call",
+			msg:  Message{Role: "assistant", Channel: ChannelCommentary, Content: `{}`, To: "functions.x", IsCall: true},
+		},
+		{
+			name: "Valid tool result",
+			msg:  Message{Role: "functions.x", Channel: ChannelCommentary, Content: `{"ok": true}`, To: "assistant"},
+		},
+		{
+			name: "Valid user message without channel",
+			msg:  Message{Role: "user", Channel: ChannelNone, Content: "Hi"},
+		},
+		{
+			name:        "Invalid channel",
+			msg:         Message{Role: "assistant", Channel: "invalid", Content: "Hello"},
+			expectError: true,
+		},
+		{
+			name:        "Recipient on final",
+			msg:         Message{Role: "assistant", Channel: ChannelFinal, Content: "Hello", To: "functions.x"},
+			expectError: true,
+		},
+		{
+			name:        "Call without recipient",
+			msg:         Message{Role: "assistant", Channel: ChannelCommentary, Content: `{}`, IsCall: true},
+			expectError: true,
+		},
+		{
+			name:        "Empty content",
+			msg:         Message{Role: "assistant", Channel: ChannelFinal},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.msg.Validate()
+			if (err != nil) != tt.expectError {
+				t.Errorf("Validate() error = %v, expectError %v", err, tt.expectError)
+			}
+		})
+	}
+
+	empty := Message{Role: "assistant", Channel: ChannelFinal}
+	if err := empty.ValidateAllowEmpty(); err != nil {
+		t.Errorf("ValidateAllowEmpty() error = %v", err)
+	}
+}
+
 // Benchmark tests
 func BenchmarkParseResponse(b *testing.B) {
 	parser := NewParser()
@@ -376,6 +2156,18 @@ func BenchmarkParseResponse(b *testing.B) {
 	}
 }
 
+func BenchmarkGetChannelContent(b *testing.B) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>Thinking about the request<|end|>
+<|channel|>commentary to=functions.test<|message|>{"data": "test"}<|call|>
+<|channel|>final<|message|>Here is the final response with some longer text content<|end|>`
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = parser.GetChannelContent(input, ChannelFinal)
+	}
+}
+
 func BenchmarkExtractFinalMessage(b *testing.B) {
 	parser := NewParser()
 	input := `<|channel|>analysis<|message|>Internal processing<|end|>
@@ -0,0 +1,65 @@
+// Package harmonytest provides test helpers for code built on goharmony.
+// It is kept separate from the main package so that goharmony itself does
+// not depend on the testing package.
+package harmonytest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kultivator-consulting/goharmony"
+)
+
+// AssertRoundTrip parses input, re-encodes the messages and parses them again,
+// failing the test if the two parses differ
+func AssertRoundTrip(t testing.TB, input string) {
+	t.Helper()
+
+	parser := goharmony.NewParser()
+	messages, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	encoded := goharmony.Encode(messages)
+	reparsed, err := parser.ParseResponse(encoded)
+	if err != nil {
+		t.Fatalf("ParseResponse(Encode()) error = %v", err)
+	}
+
+	if !reflect.DeepEqual(reparsed, messages) {
+		t.Errorf("round trip mismatch:\n  parsed:   %v\n  reparsed: %v\n  encoded:  %q", messages, reparsed, encoded)
+	}
+}
+
+// FeedChunks splits full into chunks of the given byte sizes, feeds them to sp
+// and returns every message emitted, including those produced by the final
+// Flush. Sizes are reused cyclically until the input is consumed; sizes below 1
+// are treated as 1. Feeding stops at the first error, returning the messages
+// collected so far.
+func FeedChunks(sp *goharmony.StreamParser, full string, sizes []int) []goharmony.Message {
+	var messages []goharmony.Message
+	for i, pos := 0, 0; pos < len(full); i++ {
+		size := 1
+		if len(sizes) > 0 && sizes[i%len(sizes)] > 1 {
+			size = sizes[i%len(sizes)]
+		}
+		end := pos + size
+		if end > len(full) {
+			end = len(full)
+		}
+
+		emitted, err := sp.Feed(full[pos:end])
+		if err != nil {
+			return messages
+		}
+		messages = append(messages, emitted...)
+		pos = end
+	}
+
+	flushed, err := sp.Flush()
+	if err != nil {
+		return messages
+	}
+	return append(messages, flushed...)
+}
@@ -0,0 +1,86 @@
+package harmonytest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kultivator-consulting/goharmony"
+)
+
+// recorder captures failures instead of failing the enclosing test
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestAssertRoundTrip(t *testing.T) {
+	inputs := []string{
+		`<|channel|>final<|message|>Hello world<|end|>`,
+		`<|channel|>analysis<|message|>Thinking...<|end|>
+<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|start|>assistant<|channel|>final<|message|>It's 72°F<|return|>`,
+		`FUNCTION_CALL: get_weather({"location": "NYC"})`,
+		"Plain text message",
+	}
+
+	for _, input := range inputs {
+		AssertRoundTrip(t, input)
+	}
+}
+
+func TestAssertRoundTrip_ReportsMismatch(t *testing.T) {
+	// Plain text keeps its leading whitespace but the re-encoded message is trimmed
+	r := &recorder{TB: t}
+	AssertRoundTrip(r, "  padded plain text")
+	if len(r.failures) != 1 {
+		t.Errorf("AssertRoundTrip() failures = %v, want exactly one", r.failures)
+	}
+}
+
+func TestFeedChunks(t *testing.T) {
+	full := `<|channel|>analysis<|message|>Thinking...<|end|>
+<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|start|>assistant<|channel|>final<|message|>It's 72°F<|return|>`
+
+	parser := goharmony.NewParser()
+	expected, err := parser.ParseResponse(full)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	// Split in the middle of the first <|message|> token
+	insideToken := strings.Index(full, "<|message|>") + 4
+
+	patterns := map[string][]int{
+		"Single bytes":       {1},
+		"Uneven sizes":       {3, 7, 2, 11},
+		"Inside a token":     {insideToken, len(full)},
+		"Whole input":        {len(full)},
+		"Multi-byte content": {strings.Index(full, "°") + 1, 1},
+	}
+
+	sp := goharmony.NewStreamParser(parser)
+	for name, sizes := range patterns {
+		t.Run(name, func(t *testing.T) {
+			sp.Reset()
+			messages := FeedChunks(sp, full, sizes)
+			if !reflect.DeepEqual(messages, expected) {
+				t.Errorf("FeedChunks(%v) = %v, want %v", sizes, messages, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package goharmony
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ToMarkdown renders a transcript as Markdown. Final content is rendered as
+// prose, analysis in a collapsed <details> block, commentary as a quote, tool
+// calls and tool results as fenced code blocks under a heading, and other
+// roles as labelled paragraphs.
+func (p *Parser) ToMarkdown(content string) string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return ""
+	}
+
+	blocks := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		blocks = append(blocks, markdownBlock(msg))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// markdownBlock renders a single message as a Markdown block
+func markdownBlock(msg Message) string {
+	if msg.IsCall {
+		_, name := splitRecipient(msg.To)
+		return "### " + name + "\n\n" + codeBlock(msg.Content)
+	}
+	if isToolRole(msg.Role) {
+		return "#### Result from " + toolName(msg) + "\n\n" + codeBlock(msg.Content)
+	}
+
+	switch {
+	case msg.Role != "assistant" && msg.Role != "":
+		return "**" + strings.ToUpper(msg.Role[:1]) + msg.Role[1:] + ":** " + msg.Content
+	case msg.Channel == ChannelAnalysis:
+		return "<details>\n<summary>" + msg.Channel.DisplayName() + "</summary>\n\n" + msg.Content + "\n\n</details>"
+	case msg.Channel == ChannelCommentary:
+		return "> " + strings.ReplaceAll(msg.Content, "\n", "\n> ")
+	default:
+		return msg.Content
+	}
+}
+
+// codeBlock wraps content in a fenced code block, tagged as JSON when valid
+func codeBlock(content string) string {
+	lang := ""
+	if json.Valid([]byte(content)) {
+		lang = "json"
+	}
+	return "```" + lang + "\n" + content + "\n```"
+}
@@ -0,0 +1,23 @@
+package goharmony
+
+import "testing"
+
+func TestToMarkdown(t *testing.T) {
+	parser := NewParser()
+	input := `<|start|>user<|message|>What's the weather in NYC?<|end|>
+<|start|>assistant<|channel|>analysis<|message|>Need weather data<|end|>
+<|start|>assistant<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|start|>assistant<|channel|>final<|message|>It's 72°F in NYC.<|return|>`
+
+	expected := "**User:** What's the weather in NYC?\n\n" +
+		"<details>\n<summary>Analysis</summary>\n\nNeed weather data\n\n</details>\n\n" +
+		"### get_weather\n\n```json\n{\"location\": \"NYC\"}\n```\n\n" +
+		"#### Result from get_weather\n\n```json\n{\"temperature\": 72}\n```\n\n" +
+		"It's 72°F in NYC."
+
+	result := parser.ToMarkdown(input)
+	if result != expected {
+		t.Errorf("ToMarkdown() = %v, want %v", result, expected)
+	}
+}
@@ -0,0 +1,317 @@
+package goharmony
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// chunkStateVersion identifies the layout of the opaque ParseChunk state
+const chunkStateVersion byte = 1
+
+// terminators lists the tokens that complete a Harmony message
+var terminators = []string{"<|end|>", "<|call|>", "<|return|>"}
+
+// ParseChunk parses a chunk of a larger response, resuming from a previous state.
+// It returns the messages completed by this chunk and a new opaque state holding
+// the unterminated remainder. Pass a nil state for the first chunk. Text that is
+// never terminated stays in the state and is not returned.
+func (p *Parser) ParseChunk(state []byte, chunk string) (messages []Message, newState []byte, err error) {
+	pending, err := decodeChunkState(state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buffer := pending + chunk
+	end := lastTerminatorEnd(buffer)
+	if end == -1 {
+		return nil, encodeChunkState(buffer), nil
+	}
+
+	messages, err = p.ParseResponse(buffer[:end])
+	if err != nil {
+		return nil, nil, err
+	}
+	return messages, encodeChunkState(buffer[end:]), nil
+}
+
+// encodeChunkState serializes pending text into an opaque state
+func encodeChunkState(pending string) []byte {
+	state := make([]byte, 0, len(pending)+1)
+	state = append(state, chunkStateVersion)
+	return append(state, pending...)
+}
+
+// decodeChunkState extracts pending text from an opaque state
+func decodeChunkState(state []byte) (string, error) {
+	if len(state) == 0 {
+		return "", nil
+	}
+	if state[0] != chunkStateVersion {
+		return "", errors.New("unsupported chunk state version")
+	}
+	return string(state[1:]), nil
+}
+
+// lastTerminatorEnd returns the index just past the last message terminator, or -1
+func lastTerminatorEnd(content string) int {
+	end := -1
+	for _, token := range terminators {
+		if i := strings.LastIndex(content, token); i != -1 && i+len(token) > end {
+			end = i + len(token)
+		}
+	}
+	return end
+}
+
+// StreamParser incrementally parses one streamed response at a time.
+// Feed chunks as they arrive, call Flush once the stream ends, then call
+// NextResponse or Reset before feeding the next response. The parser reuses
+// its buffers across responses, so it must not be shared between goroutines.
+type StreamParser struct {
+	parser   *Parser
+	pending  []byte
+	messages []Message
+}
+
+// NewStreamParser creates a stream parser backed by the given parser.
+// A nil parser uses NewParser().
+func NewStreamParser(parser *Parser) *StreamParser {
+	if parser == nil {
+		parser = NewParser()
+	}
+	return &StreamParser{parser: parser}
+}
+
+// Feed appends a chunk and returns the messages it completed
+func (sp *StreamParser) Feed(chunk string) ([]Message, error) {
+	sp.pending = append(sp.pending, chunk...)
+	end := lastTerminatorEnd(string(sp.pending))
+	if end == -1 {
+		return nil, nil
+	}
+
+	messages, err := sp.parser.ParseResponse(string(sp.pending[:end]))
+	if err != nil {
+		return nil, err
+	}
+	sp.pending = append(sp.pending[:0], sp.pending[end:]...)
+	sp.messages = append(sp.messages, messages...)
+	return messages, nil
+}
+
+// Flush parses any unterminated remainder and returns the messages it produced
+func (sp *StreamParser) Flush() ([]Message, error) {
+	if strings.TrimSpace(string(sp.pending)) == "" {
+		sp.pending = sp.pending[:0]
+		return nil, nil
+	}
+
+	messages, err := sp.parser.ParseResponse(string(sp.pending))
+	if err != nil {
+		return nil, err
+	}
+	sp.pending = sp.pending[:0]
+	sp.messages = append(sp.messages, messages...)
+	return messages, nil
+}
+
+// Messages returns all messages completed so far in the current response
+func (sp *StreamParser) Messages() []Message {
+	return sp.messages
+}
+
+// Reset discards the current response so the stream parser can be reused
+func (sp *StreamParser) Reset() {
+	sp.pending = sp.pending[:0]
+	sp.messages = nil
+}
+
+// NextResponse flushes the current response, returns all of its messages and
+// resets the stream parser for the next response
+func (sp *StreamParser) NextResponse() ([]Message, error) {
+	if _, err := sp.Flush(); err != nil {
+		sp.Reset()
+		return nil, err
+	}
+	messages := sp.messages
+	sp.Reset()
+	return messages, nil
+}
+
+// ParseSince parses the complete messages of a growing buffer that follow
+// consumedBytes, along with the new watermark: the end of the last complete
+// message. Only the text between the old and new watermarks is parsed, so pass
+// the returned watermark (or 0 on the first call) back on the next call.
+func (p *Parser) ParseSince(content string, consumedBytes int) ([]Message, int) {
+	if consumedBytes < 0 {
+		consumedBytes = 0
+	}
+	if consumedBytes >= len(content) {
+		return nil, consumedBytes
+	}
+
+	tail := content[consumedBytes:]
+	end := lastTerminatorEnd(tail)
+	if end == -1 {
+		return nil, consumedBytes
+	}
+
+	messages, err := p.ParseResponse(tail[:end])
+	if err != nil {
+		return nil, consumedBytes
+	}
+	return messages, consumedBytes + end
+}
+
+// sseDone is the data payload servers send to mark the end of an SSE stream
+const sseDone = "[DONE]"
+
+// ParseSSE reads Server-Sent Events whose data fields carry raw Harmony text,
+// reassembles the payload and parses it. Multi-line data fields are joined with
+// newlines, other fields and comments are ignored, and reading stops at [DONE].
+func (p *Parser) ParseSSE(r io.Reader) ([]Message, error) {
+	var payload strings.Builder
+	var data []string
+	flush := func() bool {
+		if data == nil {
+			return false
+		}
+		event := strings.Join(data, "\n")
+		data = nil
+		if event == sseDone {
+			return true
+		}
+		payload.WriteString(event)
+		return false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if flush() {
+				return p.ParseResponse(payload.String())
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+		} else if line == "data" {
+			data = append(data, "")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return p.ParseResponse(payload.String())
+}
+
+// ToSSEEvents renders each parsed message as a Server-Sent Event frame followed
+// by a terminating [DONE] frame, for replaying stored responses through a
+// streaming client. Each frame ends with a blank line, so joining the frames
+// yields a stream ParseSSE can read.
+func (p *Parser) ToSSEEvents(content string) []string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	ts := DefaultTokenSet()
+	events := make([]string, 0, len(messages)+1)
+	for _, msg := range messages {
+		events = append(events, sseFrame(msg.Render(ts)))
+	}
+	return append(events, sseFrame(sseDone))
+}
+
+// sseFrame formats data as an SSE event, one data field per line
+func sseFrame(data string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// FinalDelta returns the final-channel text present in current but not in prev.
+// If the final content was rewritten rather than appended to, the full current
+// final text is returned.
+func (p *Parser) FinalDelta(prev, current string) string {
+	before := p.streamingFinal(prev)
+	after := p.streamingFinal(current)
+	if strings.HasPrefix(after, before) {
+		return after[len(before):]
+	}
+	return after
+}
+
+// streamingFinal returns the final-channel text of a possibly partial response.
+// The plain text fallback is ignored for input containing Harmony tokens, since
+// a partial header like "<|channel|>fin" is not user-facing content. Likewise a
+// trailing prefix of a special token, left when a chunk ends inside the token,
+// is dropped from an unterminated last message.
+func (p *Parser) streamingFinal(content string) string {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return ""
+	}
+
+	trimmed := strings.TrimRightFunc(content, unicode.IsSpace)
+	unterminated := lastTerminatorEnd(trimmed) != len(trimmed)
+	fallback := sanitizeInput(content)
+	var parts []string
+	for i, msg := range messages {
+		if msg.Channel != ChannelFinal || msg.IsCall || (msg.Content == fallback && strings.Contains(content, "<|")) {
+			continue
+		}
+		if unterminated && i == len(messages)-1 {
+			msg.Content = trimPartialToken(msg.Content)
+		}
+		parts = append(parts, msg.Content)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// specialTokens lists every Harmony special token
+var specialTokens = []string{
+	"<|start|>", "<|channel|>", "<|message|>", "<|constrain|>", "<|end|>", "<|call|>", "<|return|>",
+}
+
+// trimPartialToken removes the longest trailing proper prefix of a special
+// token from s, along with any whitespace before it
+func trimPartialToken(s string) string {
+	longest := 0
+	for _, token := range specialTokens {
+		for n := len(token) - 1; n > longest; n-- {
+			if strings.HasSuffix(s, token[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	if longest == 0 {
+		return s
+	}
+	return strings.TrimRightFunc(s[:len(s)-longest], unicode.IsSpace)
+}
+
+// IsTurnComplete reports whether the last terminator in content is <|return|>,
+// which servers emit once the assistant's turn is fully done
+func (p *Parser) IsTurnComplete(content string) bool {
+	last, lastToken := -1, ""
+	for _, token := range terminators {
+		if i := strings.LastIndex(content, token); i > last {
+			last, lastToken = i, token
+		}
+	}
+	return lastToken == "<|return|>"
+}
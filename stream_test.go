@@ -0,0 +1,292 @@
+package goharmony
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChunk(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>Thinking about it<|end|>
+<|channel|>commentary to=functions.search<|message|>{"query": "news"}<|call|>
+<|channel|>final<|message|>Here are the headlines<|end|>`
+
+	expected, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	// Split in the middle of the commentary message
+	split := 70
+	first, state, err := parser.ParseChunk(nil, input[:split])
+	if err != nil {
+		t.Fatalf("ParseChunk() error = %v", err)
+	}
+	if len(first) != 1 {
+		t.Fatalf("ParseChunk() first = %v, want only the completed analysis message", first)
+	}
+
+	second, state, err := parser.ParseChunk(state, input[split:])
+	if err != nil {
+		t.Fatalf("ParseChunk() error = %v", err)
+	}
+
+	messages := append(first, second...)
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseChunk() = %v, want %v", messages, expected)
+	}
+	if len(state) != 1 {
+		t.Errorf("ParseChunk() left pending state %q", state[1:])
+	}
+}
+
+func TestParseChunk_InvalidState(t *testing.T) {
+	parser := NewParser()
+	if _, _, err := parser.ParseChunk([]byte{0xff}, "text"); err == nil {
+		t.Error("ParseChunk() expected error for invalid state")
+	}
+}
+
+func TestStreamParser_NextResponse(t *testing.T) {
+	parser := NewParser()
+	responses := []string{
+		`<|channel|>analysis<|message|>Thinking<|end|>
+<|channel|>commentary to=functions.search<|message|>{"query": "news"}<|call|>`,
+		`<|channel|>analysis<|message|>Done searching<|end|>
+<|channel|>final<|message|>Here are the headlines`,
+	}
+
+	sp := NewStreamParser(parser)
+	for _, response := range responses {
+		expected, err := parser.ParseResponse(response)
+		if err != nil {
+			t.Fatalf("ParseResponse() error = %v", err)
+		}
+
+		for i := 0; i < len(response); i += 16 {
+			end := i + 16
+			if end > len(response) {
+				end = len(response)
+			}
+			if _, err := sp.Feed(response[i:end]); err != nil {
+				t.Fatalf("Feed() error = %v", err)
+			}
+		}
+
+		messages, err := sp.NextResponse()
+		if err != nil {
+			t.Fatalf("NextResponse() error = %v", err)
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Errorf("NextResponse() = %v, want %v", messages, expected)
+		}
+		if len(sp.Messages()) != 0 {
+			t.Errorf("Messages() after NextResponse() = %v, want none", sp.Messages())
+		}
+	}
+}
+
+func TestParseSSE(t *testing.T) {
+	parser := NewParser()
+	stream := ": keep-alive\n\n" +
+		"data: <|channel|>analysis<|message|>Think\n\n" +
+		"event: chunk\ndata: ing<|end|>\n\n" +
+		"data: <|channel|>final<|message|>Line one\ndata: Line two<|end|>\r\n\r\n" +
+		"data: [DONE]\n\n" +
+		"data: <|channel|>final<|message|>Ignored<|end|>\n\n"
+
+	expected := []Message{
+		{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking"},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Line one\nLine two"},
+	}
+	messages, err := parser.ParseSSE(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("ParseSSE() error = %v", err)
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseSSE() = %v, want %v", messages, expected)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	parser := NewParser()
+	first := `<|channel|>analysis<|message|>Thinking<|end|>`
+	second := `<|channel|>commentary to=functions.search<|message|>{"q": "news"}<|call|>`
+	third := `<|channel|>final<|message|>Here you go<|end|>`
+
+	// First step: one complete message and the start of the next
+	buffer := first + second[:20]
+	messages, consumed := parser.ParseSince(buffer, 0)
+	expected := []Message{{Role: "assistant", Channel: ChannelAnalysis, Content: "Thinking"}}
+	if !reflect.DeepEqual(messages, expected) || consumed != len(first) {
+		t.Fatalf("ParseSince() = %v, %d, want %v, %d", messages, consumed, expected, len(first))
+	}
+
+	// No new complete message yet
+	if messages, next := parser.ParseSince(buffer, consumed); messages != nil || next != consumed {
+		t.Errorf("ParseSince() without growth = %v, %d, want nil, %d", messages, next, consumed)
+	}
+
+	// Second step: the rest of the call and a final answer
+	buffer = first + second + third
+	messages, consumed = parser.ParseSince(buffer, consumed)
+	expected = []Message{
+		{Role: "assistant", Channel: ChannelCommentary, Content: `{"q": "news"}`, To: "functions.search", IsCall: true, ArgsAreJSON: true, Complete: true},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Here you go"},
+	}
+	if !reflect.DeepEqual(messages, expected) || consumed != len(buffer) {
+		t.Errorf("ParseSince() = %v, %d, want %v, %d", messages, consumed, expected, len(buffer))
+	}
+	// Text before the watermark is not parsed again: a strict parser would
+	// reject the invalid channel if it were
+	config := DefaultConfig()
+	config.StrictMode = true
+	invalid := `<|channel|>bogus<|message|>Already consumed<|end|>`
+	messages, consumed = NewParserWithConfig(config).ParseSince(invalid+third, len(invalid))
+	expected = []Message{{Role: "assistant", Channel: ChannelFinal, Content: "Here you go"}}
+	if !reflect.DeepEqual(messages, expected) || consumed != len(invalid+third) {
+		t.Errorf("ParseSince() = %v, %d, want %v, %d", messages, consumed, expected, len(invalid+third))
+	}
+}
+
+func TestToSSEEvents(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>Thinking<|end|>
+<|channel|>final<|message|>Line one
+Line two<|end|>`
+
+	expected := []string{
+		"data: <|start|>assistant<|channel|>analysis<|message|>Thinking<|end|>\n\n",
+		"data: <|start|>assistant<|channel|>final<|message|>Line one\ndata: Line two<|end|>\n\n",
+		"data: [DONE]\n\n",
+	}
+	events := parser.ToSSEEvents(input)
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("ToSSEEvents() = %q, want %q", events, expected)
+	}
+
+	messages, err := parser.ParseSSE(strings.NewReader(strings.Join(events, "")))
+	if err != nil {
+		t.Fatalf("ParseSSE() error = %v", err)
+	}
+	want, _ := parser.ParseResponse(input)
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("ParseSSE(ToSSEEvents()) = %v, want %v", messages, want)
+	}
+}
+
+func TestFinalDelta(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		prev     string
+		current  string
+		expected string
+	}{
+		{
+			name:     "Appended content",
+			prev:     `<|channel|>final<|message|>Based on my analysis, `,
+			current:  `<|channel|>final<|message|>Based on my analysis, the answer is 42`,
+			expected: " the answer is 42",
+		},
+		{
+			name:     "Rewritten content",
+			prev:     `<|channel|>final<|message|>The answer is 41`,
+			current:  `<|channel|>final<|message|>Actually, the answer is 42<|end|>`,
+			expected: "Actually, the answer is 42",
+		},
+		{
+			name:     "Partial header",
+			prev:     `<|channel|>analysis<|message|>Thinking<|end|>`,
+			current:  `<|channel|>analysis<|message|>Thinking<|end|><|channel|>fin`,
+			expected: "",
+		},
+		{
+			name:     "Partial header after newline",
+			prev:     "",
+			current:  "\n<|channel|>fin",
+			expected: "",
+		},
+		{
+			name:     "First final content",
+			prev:     `<|channel|>analysis<|message|>Thinking<|end|><|channel|>`,
+			current:  `<|channel|>analysis<|message|>Thinking<|end|><|channel|>final<|message|>Hi`,
+			expected: "Hi",
+		},
+		{
+			name:     "Chunk ends inside the terminator",
+			prev:     `<|channel|>final<|message|>Hel`,
+			current:  `<|channel|>final<|message|>Hello <|e`,
+			expected: "lo",
+		},
+		{
+			name:     "Terminator completed",
+			prev:     `<|channel|>final<|message|>Hello <|e`,
+			current:  `<|channel|>final<|message|>Hello <|end|>`,
+			expected: "",
+		},
+		{
+			name:     "Content continues after a lone angle bracket",
+			prev:     `<|channel|>final<|message|>a <`,
+			current:  `<|channel|>final<|message|>a < b`,
+			expected: " < b",
+		},
+		{
+			name:     "No change",
+			prev:     `<|channel|>final<|message|>Hi<|end|>`,
+			current:  `<|channel|>final<|message|>Hi<|end|>`,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.FinalDelta(tt.prev, tt.current)
+			if result != tt.expected {
+				t.Errorf("FinalDelta() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsTurnComplete(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "End terminated",
+			input:    `<|channel|>analysis<|message|>Thinking<|end|>`,
+			expected: false,
+		},
+		{
+			name:     "Return terminated",
+			input:    `<|channel|>analysis<|message|>Thinking<|end|><|start|>assistant<|channel|>final<|message|>Done<|return|>`,
+			expected: true,
+		},
+		{
+			name:     "Call after earlier return",
+			input:    `<|channel|>final<|message|>Done<|return|><|channel|>commentary to=functions.x<|message|>{}<|call|>`,
+			expected: false,
+		},
+		{
+			name:     "No terminator",
+			input:    `<|channel|>final<|message|>Stream`,
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parser.IsTurnComplete(tt.input)
+			if result != tt.expected {
+				t.Errorf("IsTurnComplete() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package goharmony
+
+import (
+	"regexp"
+	"strings"
+)
+
+// TokenKind classifies a lexical token in a Harmony response
+type TokenKind string
+
+const (
+	// TokenStart is the <|start|> special token
+	TokenStart TokenKind = "start"
+	// TokenChannel is the <|channel|> special token
+	TokenChannel TokenKind = "channel"
+	// TokenMessage is the <|message|> special token
+	TokenMessage TokenKind = "message"
+	// TokenEnd is the <|end|> special token
+	TokenEnd TokenKind = "end"
+	// TokenCall is the <|call|> special token
+	TokenCall TokenKind = "call"
+	// TokenReturn is the <|return|> special token
+	TokenReturn TokenKind = "return"
+	// TokenConstrain is the <|constrain|> special token
+	TokenConstrain TokenKind = "constrain"
+	// TokenRole is the role word following <|start|>
+	TokenRole TokenKind = "role"
+	// TokenChannelName is the channel keyword following <|channel|>
+	TokenChannelName TokenKind = "channel_name"
+	// TokenRecipient is the target of a to= attribute, without the "to=" prefix
+	TokenRecipient TokenKind = "recipient"
+	// TokenContentType is the content type following <|constrain|>
+	TokenContentType TokenKind = "content_type"
+	// TokenContent is a run of message content
+	TokenContent TokenKind = "content"
+	// TokenText is any other non-whitespace text
+	TokenText TokenKind = "text"
+)
+
+// Token is a lexical token with its byte offsets in the input
+type Token struct {
+	// Kind of token
+	Kind TokenKind `json:"kind"`
+	// Value is the token text
+	Value string `json:"value"`
+	// Start is the byte offset of the first character
+	Start int `json:"start"`
+	// End is the byte offset just past the last character
+	End int `json:"end"`
+}
+
+// specialTokenPattern matches Harmony special tokens
+var specialTokenPattern = regexp.MustCompile(`<\|(start|channel|message|end|call|return|constrain)\|>`)
+
+// headerFieldPattern matches whitespace-separated words in a message header
+var headerFieldPattern = regexp.MustCompile(`\S+`)
+
+// Tokenize splits a response into special tokens, header words and content runs.
+// It works on the raw input and does not validate message structure.
+func (p *Parser) Tokenize(content string) []Token {
+	var tokens []Token
+	var after TokenKind
+	pos := 0
+
+	for _, loc := range specialTokenPattern.FindAllStringSubmatchIndex(content, -1) {
+		tokens = appendSegment(tokens, content, pos, loc[0], after)
+		after = TokenKind(content[loc[2]:loc[3]])
+		tokens = append(tokens, Token{Kind: after, Value: content[loc[0]:loc[1]], Start: loc[0], End: loc[1]})
+		pos = loc[1]
+	}
+	return appendSegment(tokens, content, pos, len(content), after)
+}
+
+// appendSegment tokenizes the text between two special tokens, where after is
+// the kind of the special token preceding the segment
+func appendSegment(tokens []Token, content string, start, end int, after TokenKind) []Token {
+	if start == end {
+		return tokens
+	}
+	if after == TokenMessage {
+		return append(tokens, Token{Kind: TokenContent, Value: content[start:end], Start: start, End: end})
+	}
+
+	inHeader := after == TokenStart || after == TokenChannel || after == TokenConstrain
+	for i, loc := range headerFieldPattern.FindAllStringIndex(content[start:end], -1) {
+		tok := Token{Kind: TokenText, Value: content[start+loc[0] : start+loc[1]], Start: start + loc[0], End: start + loc[1]}
+		switch {
+		case inHeader && strings.HasPrefix(tok.Value, "to=") && len(tok.Value) > len("to="):
+			tok.Kind, tok.Value, tok.Start = TokenRecipient, tok.Value[3:], tok.Start+3
+		case i == 0 && after == TokenStart:
+			tok.Kind = TokenRole
+		case i == 0 && after == TokenChannel:
+			tok.Kind = TokenChannelName
+		case i == 0 && after == TokenConstrain:
+			tok.Kind = TokenContentType
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
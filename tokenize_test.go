@@ -0,0 +1,58 @@
+package goharmony
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	parser := NewParser()
+	input := "<|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{\"x\": 1}<|call|>\n<|channel|>final<|message|>Hi<|end|>"
+
+	expected := []Token{
+		{Kind: TokenStart, Value: "<|start|>", Start: 0, End: 9},
+		{Kind: TokenRole, Value: "assistant", Start: 9, End: 18},
+		{Kind: TokenChannel, Value: "<|channel|>", Start: 18, End: 29},
+		{Kind: TokenChannelName, Value: "commentary", Start: 29, End: 39},
+		{Kind: TokenRecipient, Value: "functions.get_weather", Start: 43, End: 64},
+		{Kind: TokenConstrain, Value: "<|constrain|>", Start: 65, End: 78},
+		{Kind: TokenContentType, Value: "json", Start: 78, End: 82},
+		{Kind: TokenMessage, Value: "<|message|>", Start: 82, End: 93},
+		{Kind: TokenContent, Value: `{"x": 1}`, Start: 93, End: 101},
+		{Kind: TokenCall, Value: "<|call|>", Start: 101, End: 109},
+		{Kind: TokenChannel, Value: "<|channel|>", Start: 110, End: 121},
+		{Kind: TokenChannelName, Value: "final", Start: 121, End: 126},
+		{Kind: TokenMessage, Value: "<|message|>", Start: 126, End: 137},
+		{Kind: TokenContent, Value: "Hi", Start: 137, End: 139},
+		{Kind: TokenEnd, Value: "<|end|>", Start: 139, End: 146},
+	}
+
+	tokens := parser.Tokenize(input)
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Tokenize() = %v, want %v", tokens, expected)
+	}
+	for _, tok := range tokens {
+		if input[tok.Start:tok.End] != tok.Value {
+			t.Errorf("Token %v does not match input %q", tok, input[tok.Start:tok.End])
+		}
+	}
+}
+
+func TestTokenize_TextOutsideMessages(t *testing.T) {
+	parser := NewParser()
+	input := "Preamble <|channel|>final<|message|>Hi<|end|> trailing"
+
+	expected := []Token{
+		{Kind: TokenText, Value: "Preamble", Start: 0, End: 8},
+		{Kind: TokenChannel, Value: "<|channel|>", Start: 9, End: 20},
+		{Kind: TokenChannelName, Value: "final", Start: 20, End: 25},
+		{Kind: TokenMessage, Value: "<|message|>", Start: 25, End: 36},
+		{Kind: TokenContent, Value: "Hi", Start: 36, End: 38},
+		{Kind: TokenEnd, Value: "<|end|>", Start: 38, End: 45},
+		{Kind: TokenText, Value: "trailing", Start: 46, End: 54},
+	}
+
+	if tokens := parser.Tokenize(input); !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Tokenize() = %v, want %v", tokens, expected)
+	}
+}
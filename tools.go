@@ -0,0 +1,514 @@
+package goharmony
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FunctionCall represents a tool call made by the assistant
+type FunctionCall struct {
+	// Namespace of the tool (e.g., "functions")
+	Namespace string `json:"namespace"`
+	// Name of the function being called (e.g., "get_weather")
+	Name string `json:"name"`
+	// Args is the raw argument payload of the call
+	Args string `json:"args"`
+}
+
+// ArgsObject parses the call arguments and requires them to be a JSON object
+func (fc FunctionCall) ArgsObject() (map[string]interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(fc.Args), &value); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments for %s: %w", fc.Name, err)
+	}
+
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("arguments for %s are not a JSON object: %s", fc.Name, jsonKind(value))
+	}
+	return obj, nil
+}
+
+// CanonicalArgs re-encodes the JSON arguments with sorted keys and no insignificant
+// whitespace, so equivalent arguments produce the same string (e.g., for cache keys)
+func (fc FunctionCall) CanonicalArgs() (string, error) {
+	if !json.Valid([]byte(fc.Args)) {
+		return "", fmt.Errorf("arguments for %s are not valid JSON", fc.Name)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(fc.Args))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", fmt.Errorf("failed to parse arguments for %s: %w", fc.Name, err)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", fmt.Errorf("failed to encode arguments for %s: %w", fc.Name, err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// ArgsTrimmed returns the call arguments with surrounding whitespace removed
+func (fc FunctionCall) ArgsTrimmed() string {
+	return strings.TrimSpace(fc.Args)
+}
+
+// ToolResult represents the output of a tool addressed back to the model
+type ToolResult struct {
+	// Name of the tool that produced the result (e.g., "get_weather")
+	Name string `json:"name"`
+	// Content of the tool output
+	Content string `json:"content"`
+	// IsError indicates the output is a JSON object carrying an "error" field
+	IsError bool `json:"is_error,omitempty"`
+}
+
+// ToolDefinition describes a tool declared in a developer message
+type ToolDefinition struct {
+	// Namespace declaring the tool (e.g., "functions")
+	Namespace string `json:"namespace"`
+	// Name of the tool (e.g., "get_weather")
+	Name string `json:"name"`
+	// Signature is the TypeScript-like type of the tool (e.g., "() => any")
+	Signature string `json:"signature"`
+}
+
+// toolNamespacePattern matches a "namespace name { ... } // namespace name" block
+var toolNamespacePattern = regexp.MustCompile(`(?s)namespace\s+(\w+)\s*\{(.*?)(?:\}\s*//\s*namespace\b|$)`)
+
+// toolTypePattern matches a "type name = (...) => result;" declaration
+var toolTypePattern = regexp.MustCompile(`(?s)type\s+(\w+)\s*=\s*(\(.*?\)\s*=>\s*[^;]+);`)
+
+// ExtractToolDefinitions parses the tool namespaces declared in developer messages
+func (p *Parser) ExtractToolDefinitions(content string) []ToolDefinition {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	var definitions []ToolDefinition
+	for _, msg := range messages {
+		if msg.Role != "developer" {
+			continue
+		}
+		for _, block := range toolNamespacePattern.FindAllStringSubmatch(msg.Content, -1) {
+			for _, decl := range toolTypePattern.FindAllStringSubmatch(block[2], -1) {
+				definitions = append(definitions, ToolDefinition{
+					Namespace: block[1],
+					Name:      decl[1],
+					Signature: strings.TrimSpace(decl[2]),
+				})
+			}
+		}
+	}
+	return definitions
+}
+
+// ToolCallContext pairs a tool call with the reasoning that led to it
+type ToolCallContext struct {
+	// Reasoning is the analysis and commentary text immediately preceding the call
+	Reasoning string `json:"reasoning"`
+	// Call is the tool call
+	Call FunctionCall `json:"call"`
+}
+
+// ToolCallsWithContext returns each tool call with the run of analysis and
+// commentary messages directly before it, joined with newlines
+func (p *Parser) ToolCallsWithContext(content string) []ToolCallContext {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	var results []ToolCallContext
+	var reasoning []string
+	for _, msg := range messages {
+		namespace, name := splitRecipient(msg.To)
+		switch {
+		case msg.IsCall && namespace != "":
+			results = append(results, ToolCallContext{
+				Reasoning: strings.Join(reasoning, "\n"),
+				Call:      FunctionCall{Namespace: namespace, Name: name, Args: msg.Content},
+			})
+			reasoning = nil
+		case (msg.Channel == ChannelAnalysis || msg.Channel == ChannelCommentary) && !isToolRole(msg.Role):
+			reasoning = append(reasoning, msg.Content)
+		default:
+			reasoning = nil
+		}
+	}
+	return results
+}
+
+// ExtractAllFunctionCalls extracts every function call from a Harmony response in order
+func (p *Parser) ExtractAllFunctionCalls(content string) []FunctionCall {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	var calls []FunctionCall
+	for _, msg := range messages {
+		if !msg.IsCall || msg.To == "" {
+			continue
+		}
+		namespace, name := splitRecipient(msg.To)
+		if namespace == "" {
+			continue
+		}
+		calls = append(calls, FunctionCall{Namespace: namespace, Name: name, Args: msg.Content})
+	}
+
+	// Also check for FUNCTION_CALL format
+	if len(calls) == 0 {
+		for _, loc := range p.functionPattern.FindAllStringSubmatchIndex(content, -1) {
+			calls = append(calls, FunctionCall{
+				Namespace: "functions",
+				Name:      namedGroup(p.functionPattern, content, loc, "name"),
+				Args:      namedGroup(p.functionPattern, content, loc, "args"),
+			})
+		}
+	}
+
+	return calls
+}
+
+// FirstActionable returns the first message that is a tool call or a non-empty
+// final answer. It parses progressively larger prefixes of the response, so
+// messages after the actionable one are usually not parsed.
+func (p *Parser) FirstActionable(content string) (Message, bool) {
+	for limit := 1; ; limit *= 2 {
+		messages, err := p.ParseResponseLimit(content, limit)
+		if err != nil {
+			return Message{}, false
+		}
+		for _, msg := range messages {
+			isCall := msg.IsCall && msg.To != ""
+			isFinal := !msg.IsCall && msg.Channel == ChannelFinal && strings.TrimSpace(msg.Content) != ""
+			if isCall || isFinal {
+				return msg, true
+			}
+		}
+		if len(messages) < limit {
+			return Message{}, false
+		}
+	}
+}
+
+// GetToolCallAt returns the function call at the given position in the response
+func (p *Parser) GetToolCallAt(content string, index int) (FunctionCall, bool) {
+	calls := p.ExtractAllFunctionCalls(content)
+	if index < 0 || index >= len(calls) {
+		return FunctionCall{}, false
+	}
+	return calls[index], true
+}
+
+// ExtractLastFunctionCall extracts the most recent function call in a response,
+// which is usually the one an agent loop should act on
+func (p *Parser) ExtractLastFunctionCall(content string) (name, args string, ok bool) {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return "", "", false
+	}
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if !msg.IsCall || !msg.Complete {
+			continue
+		}
+		if namespace, name := splitRecipient(msg.To); namespace != "" {
+			return name, msg.Content, true
+		}
+	}
+
+	// Also check for FUNCTION_CALL format
+	if locs := p.functionPattern.FindAllStringSubmatchIndex(content, -1); len(locs) > 0 {
+		last := locs[len(locs)-1]
+		return namedGroup(p.functionPattern, content, last, "name"), namedGroup(p.functionPattern, content, last, "args"), true
+	}
+	return "", "", false
+}
+
+// ExtractCompleteFunctionCall extracts the first function call whose terminator
+// has arrived and whose arguments are complete JSON. It is safe to call on a
+// partial streaming buffer.
+func (p *Parser) ExtractCompleteFunctionCall(content string) (name, args string, ok bool) {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return "", "", false
+	}
+
+	for _, msg := range messages {
+		if !msg.IsCall || !msg.ArgsAreJSON {
+			continue
+		}
+		if namespace, name := splitRecipient(msg.To); namespace != "" {
+			return name, msg.Content, true
+		}
+	}
+	return "", "", false
+}
+
+// ExtractArgsFor returns the arguments of the first call to the named function
+// in any namespace
+func (p *Parser) ExtractArgsFor(content, functionName string) (string, bool) {
+	for _, call := range p.ExtractAllFunctionCalls(content) {
+		if call.Name == functionName {
+			return call.Args, true
+		}
+	}
+	return "", false
+}
+
+// CountToolCalls counts the function calls in a response without building messages.
+// It returns the same count as len(ExtractAllFunctionCalls(content)).
+func (p *Parser) CountToolCalls(content string) int {
+	// Strict mode may reject the whole response, split call arguments must be
+	// joined first and timeouts only apply to the full parse, so use the full path
+	if p.config.StrictMode || p.config.JoinSplitCallArgs || p.config.ParseTimeout > 0 {
+		return len(p.ExtractAllFunctionCalls(content))
+	}
+	content = sanitizeInput(content)
+	if p.config.StripCodeFences {
+		content, _ = stripCodeFences(content)
+	}
+
+	count := 0
+	if strings.Contains(content, "<|call|>") {
+		for _, loc := range p.messagePattern.FindAllStringSubmatchIndex(content, -1) {
+			match := p.matchMessage(content, loc)
+			if match.terminator != "<|call|>" {
+				continue
+			}
+			recipient := match.to
+			if recipient == "" {
+				recipient = match.roleTo
+			}
+			if namespace, _ := splitRecipient(recipient); namespace != "" {
+				count++
+			}
+		}
+	}
+
+	if count == 0 && strings.Contains(content, "FUNCTION_CALL:") {
+		count = len(p.functionPattern.FindAllStringIndex(content, -1))
+	}
+	return count
+}
+
+// ExtractToolResults extracts tool outputs sent from a tool namespace to the
+// assistant, or sent by the tool role (e.g., "<|start|>tool name=get_weather").
+// Tool calls (messages from the assistant to a tool) are not included.
+func (p *Parser) ExtractToolResults(content string) []ToolResult {
+	messages, err := p.ParseResponse(content)
+	if err != nil {
+		return nil
+	}
+
+	var results []ToolResult
+	for _, msg := range messages {
+		if msg.IsCall || msg.Channel != ChannelCommentary || !isToolRole(msg.Role) {
+			continue
+		}
+		if msg.Role != "tool" && msg.To != "assistant" {
+			continue
+		}
+		results = append(results, ToolResult{
+			Name:    toolName(msg),
+			Content: msg.Content,
+			IsError: isErrorPayload(msg.Content),
+		})
+	}
+	return results
+}
+
+// ExtractFunctionCallAllowed extracts a function call and rejects it if the function
+// is not in the allowed list. A response without a call returns ok=false and no error.
+func (p *Parser) ExtractFunctionCallAllowed(content string, allowed []string) (name, args string, ok bool, err error) {
+	name, args, ok = p.ExtractFunctionCall(content)
+	if !ok {
+		return "", "", false, nil
+	}
+
+	for _, a := range allowed {
+		if a == name {
+			return name, args, true, nil
+		}
+	}
+	return "", "", false, fmt.Errorf("function not allowed: %s", name)
+}
+
+// UnknownToolCalls returns every function call whose name is not in the known list.
+// Unlike ExtractFunctionCallAllowed it never fails and reports all offenders.
+func (p *Parser) UnknownToolCalls(content string, known []string) []FunctionCall {
+	knownSet := make(map[string]bool, len(known))
+	for _, name := range known {
+		knownSet[name] = true
+	}
+
+	var unknown []FunctionCall
+	for _, call := range p.ExtractAllFunctionCalls(content) {
+		if !knownSet[call.Name] {
+			unknown = append(unknown, call)
+		}
+	}
+	return unknown
+}
+
+// ValidateRouting checks that tool results are addressed to the assistant and
+// that assistant tool calls are addressed to a namespaced tool. It returns one
+// ParseError per misrouted message, or nil if routing is consistent.
+func (p *Parser) ValidateRouting(content string) []ParseError {
+	r, err := p.parseWithTimeout(content, -1)
+	if err != nil {
+		return nil
+	}
+
+	var problems []ParseError
+	for i, msg := range r.messages {
+		// Tool role messages are matched to their call by tool_call_id instead
+		routed := msg.To == "assistant" || (msg.Role == "tool" && msg.To == "")
+		switch {
+		case isToolRole(msg.Role) && !msg.IsCall && !routed:
+			problems = append(problems, ParseError{
+				Kind:   ParseErrorMisrouted,
+				Offset: r.spans[i][0],
+				Detail: fmt.Sprintf("result from %s addressed to %q, want assistant", msg.Role, msg.To),
+			})
+		case msg.IsCall && !isToolRole(msg.Role) && !isToolRole(msg.To):
+			problems = append(problems, ParseError{
+				Kind:   ParseErrorMisrouted,
+				Offset: r.spans[i][0],
+				Detail: fmt.Sprintf("call addressed to %q, want a tool recipient", msg.To),
+			})
+		}
+	}
+	return problems
+}
+
+// ParseKVArgs parses legacy key=value call arguments (e.g., query="news, today",
+// limit=5). Values may be single- or double-quoted, with backslash escapes, so
+// quoted values can contain commas and equals signs.
+func (p *Parser) ParseKVArgs(args string) (map[string]string, error) {
+	result := make(map[string]string)
+	var pairs []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range args {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			pairs = append(pairs, args[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in arguments: %s", args)
+	}
+	pairs = append(pairs, args[start:])
+
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("argument is not key=value: %s", pair)
+		}
+		result[key] = unquoteKVValue(strings.TrimSpace(value))
+	}
+	return result, nil
+}
+
+// unquoteKVValue strips matching quotes from a key=value argument and resolves
+// backslash escapes inside them
+func unquoteKVValue(value string) string {
+	if len(value) < 2 || (value[0] != '"' && value[0] != '\'') || value[len(value)-1] != value[0] {
+		return value
+	}
+
+	var b strings.Builder
+	escaped := false
+	for _, r := range value[1 : len(value)-1] {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// splitRecipient splits a "namespace.name" recipient into its parts.
+// An empty namespace is returned when the recipient is not namespaced.
+func splitRecipient(recipient string) (namespace, name string) {
+	parts := strings.SplitN(recipient, ".", 2)
+	if len(parts) != 2 {
+		return "", recipient
+	}
+	return parts[0], parts[1]
+}
+
+// isToolRole checks if a role names a tool (e.g., "functions.get_weather") or
+// is the tool role itself
+func isToolRole(role string) bool {
+	namespace, _ := splitRecipient(role)
+	return namespace != "" || role == "tool"
+}
+
+// toolName returns the name of the tool that sent msg: the name= attribute of a
+// tool message, or else the name part of its role
+func toolName(msg Message) string {
+	if msg.ToolName != "" {
+		return msg.ToolName
+	}
+	_, name := splitRecipient(msg.Role)
+	return name
+}
+
+// isErrorPayload checks if content is a JSON object with an "error" field
+func isErrorPayload(content string) bool {
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &payload); err != nil {
+		return false
+	}
+	_, ok := payload["error"]
+	return ok
+}
+
+// jsonKind describes the JSON type of a decoded value
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,738 @@
+package goharmony
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractToolResults(t *testing.T) {
+	parser := NewParser()
+
+	input := `<|start|>assistant<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|start|>functions.get_time to=assistant<|channel|>commentary<|message|>{"error": "timeout"}<|end|>
+<|start|>assistant<|channel|>final<|message|>It's 72°F in NYC.<|end|>`
+
+	messages, err := parser.ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if len(messages) != 4 || !messages[0].IsCall || messages[1].IsCall {
+		t.Fatalf("ParseResponse() = %v, want a call followed by non-call results", messages)
+	}
+
+	expected := []ToolResult{
+		{Name: "get_weather", Content: `{"temperature": 72}`},
+		{Name: "get_time", Content: `{"error": "timeout"}`, IsError: true},
+	}
+	results := parser.ExtractToolResults(input)
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("ExtractToolResults() = %v, want %v", results, expected)
+	}
+}
+
+func TestToolRoleMessages(t *testing.T) {
+	parser := NewParser()
+	input := `<|start|>user<|message|>Weather and time?<|end|>
+<|start|>assistant<|channel|>analysis<|message|>Need the weather<|end|>
+<|start|>assistant<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>tool name=get_weather tool_call_id=call_0<|channel|>commentary<|message|>{"temp":72}<|end|>
+<|start|>assistant<|channel|>commentary to=functions.get_time<|message|>{}<|call|>
+<|start|>assistant<|channel|>final<|message|>It's 72°F.<|end|>`
+
+	expectedResults := []ToolResult{{Name: "get_weather", Content: `{"temp":72}`}}
+	if results := parser.ExtractToolResults(input); !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("ExtractToolResults() = %v, want %v", results, expectedResults)
+	}
+	if status := parser.ExtractStatusLine(input); status != "" {
+		t.Errorf("ExtractStatusLine() = %q, want none", status)
+	}
+	if texts := parser.ExtractCommentaryText(input); len(texts) != 0 {
+		t.Errorf("ExtractCommentaryText() = %v, want none", texts)
+	}
+
+	contexts := parser.ToolCallsWithContext(input)
+	if len(contexts) != 2 || contexts[0].Reasoning != "Need the weather" || contexts[1].Reasoning != "" {
+		t.Errorf("ToolCallsWithContext() = %v, want the tool output excluded from reasoning", contexts)
+	}
+
+	conv := parser.BuildConversation(input)
+	if len(conv.Turns) != 2 || conv.Turns[1].Role != "assistant" || len(conv.Turns[1].Messages) != 5 {
+		t.Errorf("BuildConversation() = %v, want the tool output in the assistant turn", conv)
+	}
+
+	if md := parser.ToMarkdown(input); !strings.Contains(md, "#### Result from get_weather\n\n```json\n{\"temp\":72}\n```") {
+		t.Errorf("ToMarkdown() = %q, want a result block for get_weather", md)
+	}
+	if problems := parser.ValidateRouting(input); problems != nil {
+		t.Errorf("ValidateRouting() = %v, want nil", problems)
+	}
+}
+
+func TestExtractFunctionCallAllowed(t *testing.T) {
+	parser := NewParser()
+	allowed := []string{"get_weather", "search"}
+
+	tests := []struct {
+		name         string
+		input        string
+		expectedName string
+		expectedArgs string
+		expectedOK   bool
+		expectError  bool
+	}{
+		{
+			name:         "Allowed function",
+			input:        `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`,
+			expectedName: "get_weather",
+			expectedArgs: `{"location": "NYC"}`,
+			expectedOK:   true,
+		},
+		{
+			name:        "Disallowed function",
+			input:       `<|channel|>commentary to=functions.delete_files<|message|>{"path": "/"}<|call|>`,
+			expectError: true,
+		},
+		{
+			name:  "No function call",
+			input: `<|channel|>final<|message|>Regular message<|end|>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, args, ok, err := parser.ExtractFunctionCallAllowed(tt.input, allowed)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ExtractFunctionCallAllowed() error = %v, expectError %v", err, tt.expectError)
+			}
+			if name != tt.expectedName || args != tt.expectedArgs || ok != tt.expectedOK {
+				t.Errorf("ExtractFunctionCallAllowed() = (%v, %v, %v), want (%v, %v, %v)",
+					name, args, ok, tt.expectedName, tt.expectedArgs, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestUnknownToolCalls(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>commentary to=functions.launch_rockets<|message|>{"count": 3}<|call|>`
+
+	expected := []FunctionCall{
+		{Namespace: "functions", Name: "launch_rockets", Args: `{"count": 3}`},
+	}
+	unknown := parser.UnknownToolCalls(input, []string{"get_weather", "search"})
+	if !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("UnknownToolCalls() = %v, want %v", unknown, expected)
+	}
+}
+
+func TestExtractToolDefinitions(t *testing.T) {
+	parser := NewParser()
+	input := `<|start|>developer<|message|># Instructions
+
+Answer briefly.
+
+# Tools
+
+## functions
+
+namespace functions {
+
+// Gets the current weather in the provided location.
+type get_weather = (_: {
+location: string,
+}) => any;
+
+// Gets the current time.
+type get_time = () => any;
+
+} // namespace functions<|end|>
+<|start|>user<|message|>What's the weather?<|end|>`
+
+	expected := []ToolDefinition{
+		{Namespace: "functions", Name: "get_weather", Signature: "(_: {\nlocation: string,\n}) => any"},
+		{Namespace: "functions", Name: "get_time", Signature: "() => any"},
+	}
+	definitions := parser.ExtractToolDefinitions(input)
+	if !reflect.DeepEqual(definitions, expected) {
+		t.Errorf("ExtractToolDefinitions() = %v, want %v", definitions, expected)
+	}
+}
+
+func TestToolCallsWithContext(t *testing.T) {
+	parser := NewParser()
+	input := `<|start|>user<|message|>Weather and news?<|end|>
+<|channel|>analysis<|message|>Need the weather first<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>
+<|channel|>analysis<|message|>Now the headlines<|end|>
+<|channel|>commentary<|message|>Searching news<|end|>
+<|channel|>commentary to=functions.search<|message|>{"query": "news"}<|call|>`
+
+	expected := []ToolCallContext{
+		{
+			Reasoning: "Need the weather first",
+			Call:      FunctionCall{Namespace: "functions", Name: "get_weather", Args: `{"location": "NYC"}`},
+		},
+		{
+			Reasoning: "Now the headlines\nSearching news",
+			Call:      FunctionCall{Namespace: "functions", Name: "search", Args: `{"query": "news"}`},
+		},
+	}
+	if result := parser.ToolCallsWithContext(input); !reflect.DeepEqual(result, expected) {
+		t.Errorf("ToolCallsWithContext() = %v, want %v", result, expected)
+	}
+}
+
+func TestExtractAllFunctionCalls(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []FunctionCall
+	}{
+		{
+			name: "Multiple Harmony calls",
+			input: `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>commentary to=browser.search<|message|>{"query": "news"}<|call|>`,
+			expected: []FunctionCall{
+				{Namespace: "functions", Name: "get_weather", Args: `{"location": "NYC"}`},
+				{Namespace: "browser", Name: "search", Args: `{"query": "news"}`},
+			},
+		},
+		{
+			name:  "FUNCTION_CALL format",
+			input: `FUNCTION_CALL: calculate({"x": 5})`,
+			expected: []FunctionCall{
+				{Namespace: "functions", Name: "calculate", Args: `{"x": 5}`},
+			},
+		},
+		{
+			name:     "No function call",
+			input:    `<|channel|>final<|message|>Regular message<|end|>`,
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := parser.ExtractAllFunctionCalls(tt.input)
+			if !reflect.DeepEqual(calls, tt.expected) {
+				t.Errorf("ExtractAllFunctionCalls() = %v, want %v", calls, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFunctionCallArgsObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        string
+		expectError bool
+	}{
+		{name: "Object args", args: `{"location": "NYC"}`, expectError: false},
+		{name: "Array args", args: `["NYC"]`, expectError: true},
+		{name: "Scalar args", args: `42`, expectError: true},
+		{name: "Invalid JSON", args: `{"location": `, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := FunctionCall{Namespace: "functions", Name: "get_weather", Args: tt.args}
+			result, err := fc.ArgsObject()
+			if tt.expectError {
+				if err == nil {
+					t.Error("ArgsObject() expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ArgsObject() unexpected error: %v", err)
+			}
+			if result["location"] != "NYC" {
+				t.Errorf("ArgsObject() = %v, want location NYC", result)
+			}
+		})
+	}
+}
+
+func TestFunctionCallCanonicalArgs(t *testing.T) {
+	first := FunctionCall{Name: "search", Args: `{"a":1,"b":{"y":[1, 2],"x":"<tag>"},"n":1.50}`}
+	second := FunctionCall{Name: "search", Args: `{ "n": 1.50, "b": { "x": "<tag>", "y": [1,2] }, "a": 1 }`}
+
+	expected := `{"a":1,"b":{"x":"<tag>","y":[1,2]},"n":1.50}`
+	for _, call := range []FunctionCall{first, second} {
+		result, err := call.CanonicalArgs()
+		if err != nil {
+			t.Fatalf("CanonicalArgs() error = %v", err)
+		}
+		if result != expected {
+			t.Errorf("CanonicalArgs() = %v, want %v", result, expected)
+		}
+	}
+
+	if _, err := (FunctionCall{Name: "search", Args: "query=news"}).CanonicalArgs(); err == nil {
+		t.Error("CanonicalArgs() expected error for non-JSON args")
+	}
+}
+
+func TestFunctionCallArgsTrimmed(t *testing.T) {
+	call := FunctionCall{Namespace: "functions", Name: "search", Args: "\n\t {\"query\": \"news\"} \r\n"}
+	if result := call.ArgsTrimmed(); result != `{"query": "news"}` {
+		t.Errorf("ArgsTrimmed() = %q, want %q", result, `{"query": "news"}`)
+	}
+}
+
+func TestFirstActionable(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name       string
+		input      string
+		expected   Message
+		expectedOK bool
+	}{
+		{
+			name: "Tool call before final",
+			input: `<|channel|>analysis<|message|>Need data<|end|>
+<|channel|>commentary to=functions.search<|message|>{"q": "news"}<|call|>
+<|channel|>final<|message|>Done<|end|>`,
+			expected:   Message{Role: "assistant", Channel: ChannelCommentary, Content: `{"q": "news"}`, To: "functions.search", IsCall: true, ArgsAreJSON: true, Complete: true},
+			expectedOK: true,
+		},
+		{
+			name: "Final before tool call",
+			input: `<|channel|>analysis<|message|>Easy one<|end|>
+<|channel|>commentary<|message|>No tools needed<|end|>
+<|channel|>final<|message|>Hello<|end|>
+<|channel|>commentary to=functions.search<|message|>{"q": "news"}<|call|>`,
+			expected:   Message{Role: "assistant", Channel: ChannelFinal, Content: "Hello"},
+			expectedOK: true,
+		},
+		{
+			name:  "Nothing actionable",
+			input: `<|channel|>analysis<|message|>Still thinking<|end|>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, ok := parser.FirstActionable(tt.input)
+			if ok != tt.expectedOK || !reflect.DeepEqual(msg, tt.expected) {
+				t.Errorf("FirstActionable() = (%v, %v), want (%v, %v)", msg, ok, tt.expected, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestGetToolCallAt(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>commentary to=functions.search<|message|>{"query": "weather"}<|call|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`
+
+	tests := []struct {
+		index      int
+		expected   FunctionCall
+		expectedOK bool
+	}{
+		{index: 0, expected: FunctionCall{Namespace: "functions", Name: "search", Args: `{"query": "weather"}`}, expectedOK: true},
+		{index: 1, expected: FunctionCall{Namespace: "functions", Name: "get_weather", Args: `{"location": "NYC"}`}, expectedOK: true},
+		{index: 2},
+		{index: -1},
+	}
+
+	for _, tt := range tests {
+		call, ok := parser.GetToolCallAt(input, tt.index)
+		if call != tt.expected || ok != tt.expectedOK {
+			t.Errorf("GetToolCallAt(%d) = (%v, %v), want (%v, %v)", tt.index, call, ok, tt.expected, tt.expectedOK)
+		}
+	}
+}
+
+func TestExtractLastFunctionCall(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name         string
+		input        string
+		expectedName string
+		expectedArgs string
+		expectedOK   bool
+	}{
+		{
+			name: "Two Harmony calls",
+			input: `<|channel|>commentary to=functions.search<|message|>{"query": "weather"}<|call|>
+<|channel|>analysis<|message|>The search did not help, try the weather tool<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`,
+			expectedName: "get_weather",
+			expectedArgs: `{"location": "NYC"}`,
+			expectedOK:   true,
+		},
+		{
+			name:         "Two legacy calls",
+			input:        `FUNCTION_CALL: search({"q": "a"}) FUNCTION_CALL: fetch({"id": 1})`,
+			expectedName: "fetch",
+			expectedArgs: `{"id": 1}`,
+			expectedOK:   true,
+		},
+		{
+			name:  "No call",
+			input: `<|channel|>final<|message|>Hello<|end|>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, args, ok := parser.ExtractLastFunctionCall(tt.input)
+			if name != tt.expectedName || args != tt.expectedArgs || ok != tt.expectedOK {
+				t.Errorf("ExtractLastFunctionCall() = (%v, %v, %v), want (%v, %v, %v)",
+					name, args, ok, tt.expectedName, tt.expectedArgs, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestExtractCompleteFunctionCall(t *testing.T) {
+	parser := NewParser()
+	full := `<|channel|>analysis<|message|>Need weather<|end|>
+<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>`
+
+	tests := []struct {
+		name         string
+		input        string
+		expectedName string
+		expectedArgs string
+		expectedOK   bool
+	}{
+		{name: "Partial args", input: full[:len(full)-15]},
+		{name: "Args without terminator", input: full[:len(full)-len("<|call|>")]},
+		{name: "Partial terminator", input: full[:len(full)-3]},
+		{
+			name:         "Complete call",
+			input:        full,
+			expectedName: "get_weather",
+			expectedArgs: `{"location": "NYC"}`,
+			expectedOK:   true,
+		},
+		{name: "Malformed args", input: `<|channel|>commentary to=functions.get_weather<|message|>{"location": <|call|>`},
+		{
+			name:         "Legacy call",
+			input:        `FUNCTION_CALL: get_weather({"location": "NYC"})`,
+			expectedName: "get_weather",
+			expectedArgs: `{"location": "NYC"}`,
+			expectedOK:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, args, ok := parser.ExtractCompleteFunctionCall(tt.input)
+			if name != tt.expectedName || args != tt.expectedArgs || ok != tt.expectedOK {
+				t.Errorf("ExtractCompleteFunctionCall() = (%v, %v, %v), want (%v, %v, %v)",
+					name, args, ok, tt.expectedName, tt.expectedArgs, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestExtractArgsFor(t *testing.T) {
+	parser := NewParser()
+	input := `<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>commentary to=browser.search<|message|>{"query": "news"}<|call|>`
+
+	tests := []struct {
+		name         string
+		function     string
+		expectedArgs string
+		expectedOK   bool
+	}{
+		{name: "First function", function: "get_weather", expectedArgs: `{"location": "NYC"}`, expectedOK: true},
+		{name: "Other namespace", function: "search", expectedArgs: `{"query": "news"}`, expectedOK: true},
+		{name: "Absent function", function: "calculate", expectedArgs: "", expectedOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, ok := parser.ExtractArgsFor(input, tt.function)
+			if args != tt.expectedArgs || ok != tt.expectedOK {
+				t.Errorf("ExtractArgsFor() = (%v, %v), want (%v, %v)", args, ok, tt.expectedArgs, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecipients(t *testing.T) {
+	input := `<|channel|>commentary to=Functions.GetWeather<|message|>{"location": "NYC"}<|call|>
+<|start|>FUNCTIONS.GetWeather to=Assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>`
+
+	tests := []struct {
+		name      string
+		lowercase bool
+		expected  []string
+	}{
+		{name: "Case-sensitive function names", lowercase: false, expected: []string{"functions.GetWeather", "assistant"}},
+		{name: "Lowercased function names", lowercase: true, expected: []string{"functions.getweather", "assistant"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.NormalizeRecipients = true
+			config.LowercaseFunctionNames = tt.lowercase
+			messages, err := NewParserWithConfig(config).ParseResponse(input)
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+
+			var recipients []string
+			for _, msg := range messages {
+				recipients = append(recipients, msg.To)
+			}
+			if !reflect.DeepEqual(recipients, tt.expected) {
+				t.Errorf("recipients = %v, want %v", recipients, tt.expected)
+			}
+		})
+	}
+
+	messages, err := NewParser().ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if messages[0].To != "Functions.GetWeather" {
+		t.Errorf("To = %v, want recipient unchanged by default", messages[0].To)
+	}
+}
+
+func TestJoinSplitCallArgs(t *testing.T) {
+	input := `<|channel|>analysis<|message|>Search for news<|end|>
+<|channel|>commentary to=functions.search<|message|>{"query": "latest <|end|>
+<|channel|>commentary<|message|>news", "limit": 5}<|call|>
+<|channel|>final<|message|>Searching<|end|>`
+
+	config := DefaultConfig()
+	config.JoinSplitCallArgs = true
+	messages, err := NewParserWithConfig(config).ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	expected := []Message{
+		{Role: "assistant", Channel: ChannelAnalysis, Content: "Search for news"},
+		{
+			Role:        "assistant",
+			Channel:     ChannelCommentary,
+			Content:     `{"query": "latest news", "limit": 5}`,
+			To:          "functions.search",
+			IsCall:      true,
+			ArgsAreJSON: true,
+			Complete:    true,
+		},
+		{Role: "assistant", Channel: ChannelFinal, Content: "Searching"},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+	}
+
+	// Without the option the fragments stay separate
+	messages, err = NewParser().ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if len(messages) != 4 {
+		t.Errorf("ParseResponse() without JoinSplitCallArgs = %d messages, want 4", len(messages))
+	}
+}
+
+func TestValidateRouting(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []ParseError
+	}{
+		{
+			name: "Well-routed exchange",
+			input: `<|start|>assistant<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>`,
+		},
+		{
+			name:  "Misrouted tool result",
+			input: `<|start|>functions.get_weather to=user<|channel|>commentary<|message|>{"temperature": 72}<|end|>`,
+			expected: []ParseError{
+				{Kind: ParseErrorMisrouted, Offset: 0, Detail: `result from functions.get_weather addressed to "user", want assistant`},
+			},
+		},
+		{
+			name: "Misrouted call",
+			input: `<|channel|>analysis<|message|>Ask the user<|end|>
+<|start|>assistant<|channel|>commentary to=user<|message|>{"question": "Which city?"}<|call|>`,
+			expected: []ParseError{
+				{Kind: ParseErrorMisrouted, Offset: 50, Detail: `call addressed to "user", want a tool recipient`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := parser.ValidateRouting(tt.input)
+			if !reflect.DeepEqual(problems, tt.expected) {
+				t.Errorf("ValidateRouting() = %v, want %v", problems, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseKVArgs(t *testing.T) {
+	parser := NewParser()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "Quoted and bare values",
+			input:    `query="news", limit=5`,
+			expected: map[string]string{"query": "news", "limit": "5"},
+		},
+		{
+			name:     "Commas inside quotes",
+			input:    `query="news, sports, weather", city='Paris, France'`,
+			expected: map[string]string{"query": "news, sports, weather", "city": "Paris, France"},
+		},
+		{
+			name:     "Escaped quote and equals inside quotes",
+			input:    `expr="a=\"b, c\""`,
+			expected: map[string]string{"expr": `a="b, c"`},
+		},
+		{
+			name:     "Empty",
+			input:    "",
+			expected: map[string]string{},
+		},
+		{
+			name:    "Unterminated quote",
+			input:   `query="news, limit=5`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing key",
+			input:   `"news"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parser.ParseKVArgs(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKVArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("ParseKVArgs() = %v, want %v", args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKVCallArgs(t *testing.T) {
+	input := `FUNCTION_CALL: search(query="news, today", limit=5)`
+
+	config := DefaultConfig()
+	config.KVCallArgs = true
+	messages, err := NewParserWithConfig(config).ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+
+	expected := []Message{{
+		Role:        "assistant",
+		Channel:     ChannelCommentary,
+		Content:     `{"limit":"5","query":"news, today"}`,
+		To:          "functions.search",
+		IsCall:      true,
+		ArgsAreJSON: true,
+		Complete:    true,
+	}}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("ParseResponse() = %v, want %v", messages, expected)
+	}
+
+	// Without the option the arguments are kept verbatim
+	messages, err = NewParser().ParseResponse(input)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if len(messages) != 1 || messages[0].Content != `query="news, today", limit=5` {
+		t.Errorf("ParseResponse() without KVCallArgs = %v, want verbatim arguments", messages)
+	}
+}
+
+func TestCountToolCalls(t *testing.T) {
+	parser := NewParser()
+
+	inputs := []string{
+		`<|channel|>commentary to=functions.get_weather<|message|>{"location": "NYC"}<|call|>
+<|channel|>commentary to=browser.search<|message|>{"query": "news"}<|call|>
+<|channel|>final<|message|>Done<|end|>`,
+		`<|channel|>commentary<|message|>No recipient<|call|>`,
+		`<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{"temperature": 72}<|end|>`,
+		`FUNCTION_CALL: a({"x": 1}) then FUNCTION_CALL: b({"y": 2})`,
+		`<|channel|>final<|message|>Mentions <|call|> in passing<|end|>`,
+		`<|channel|>final<|message|>FUNCTION_CALL: test()<|end|>`,
+		`<|channel|>final<|message|>Regular message<|end|>`,
+		`<|channel|>commentary to=functions.search<|message|>{"query": "latest <|end|>
+<|channel|>commentary<|message|>news"}<|call|>`,
+		"\uFEFF\n<|channel|>commentary to=functions.x<|message|>{}<|call|>",
+		"",
+	}
+
+	configs := map[string]func(*ParserConfig){
+		"Default":           nil,
+		"JoinSplitCallArgs": func(c *ParserConfig) { c.JoinSplitCallArgs = true },
+		"ParseTimeout":      func(c *ParserConfig) { c.ParseTimeout = time.Second },
+	}
+	for name, modify := range configs {
+		p := parser.Clone(modify)
+		for _, input := range inputs {
+			expected := len(p.ExtractAllFunctionCalls(input))
+			if count := p.CountToolCalls(input); count != expected {
+				t.Errorf("%s: CountToolCalls(%q) = %d, want %d", name, input, count, expected)
+			}
+		}
+	}
+
+	if count := parser.CountToolCalls(inputs[0]); count != 2 {
+		t.Errorf("CountToolCalls() = %d, want 2", count)
+	}
+}
+
+func BenchmarkCountToolCalls(b *testing.B) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>Thinking about the request<|end|>
+<|channel|>commentary to=functions.test<|message|>{"data": "test"}<|call|>
+<|channel|>final<|message|>Here is the final response with some longer text content<|end|>`
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = parser.CountToolCalls(input)
+	}
+}
+
+func BenchmarkExtractAllFunctionCalls(b *testing.B) {
+	parser := NewParser()
+	input := `<|channel|>analysis<|message|>Thinking about the request<|end|>
+<|channel|>commentary to=functions.test<|message|>{"data": "test"}<|call|>
+<|channel|>final<|message|>Here is the final response with some longer text content<|end|>`
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = parser.ExtractAllFunctionCalls(input)
+	}
+}